@@ -12,14 +12,24 @@ import (
 	"github.com/poopmail/canalization/internal/api"
 	"github.com/poopmail/canalization/internal/config"
 	"github.com/poopmail/canalization/internal/database/postgres"
+	redisdb "github.com/poopmail/canalization/internal/database/redis"
+	"github.com/poopmail/canalization/internal/events"
 	"github.com/poopmail/canalization/internal/karen"
 	"github.com/poopmail/canalization/internal/mails"
+	"github.com/poopmail/canalization/internal/metrics"
 	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/signing"
 	"github.com/poopmail/canalization/internal/static"
+	"github.com/poopmail/canalization/internal/verification"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	// Tokens are hashed without a key if no token hashing key is set; they get invalidated as soon as one is set
+	if len(config.Loaded.TokenHashingKey) == 0 {
+		logrus.Warn("CANAL_TOKEN_HASHING_KEY is not set; hashing tokens without a key, so existing sessions and personal access tokens will be invalidated once one is set")
+	}
+
 	// Initialize the postgres database driver
 	driver, err := postgres.NewDriver(config.Loaded.PostgresDSN)
 	if err != nil {
@@ -34,6 +44,15 @@ func main() {
 	defer cancel()
 	go refreshTokenCleanup(ctx, driver.RefreshTokens, config.Loaded.RefreshTokenLifetime, config.Loaded.RefreshTokenCleanupInterval)
 
+	// Start up the mailbox expiry task
+	go mailboxExpiry(ctx, driver.Mailboxes, driver.Messages, config.Loaded.MailboxExpiryInterval)
+
+	// Start up the message retention sweeper task
+	if config.Loaded.RetentionSweepBatchSize <= 0 || config.Loaded.RetentionSweepInterval <= 0 {
+		logrus.Fatal("CANAL_RETENTION_SWEEP_BATCH_SIZE and CANAL_RETENTION_SWEEP_INTERVAL have to be greater than 0")
+	}
+	go retentionSweeper(ctx, driver.Messages, config.Loaded.MessageRetention, config.Loaded.RetentionSweepInterval, config.Loaded.RetentionSweepBatchSize)
+
 	// Initialize the Redis client
 	options, err := redis.ParseURL(config.Loaded.RedisURL)
 	if err != nil {
@@ -54,16 +73,62 @@ func main() {
 	// Initialize the karen logrus hook
 	logrus.AddHook(&karen.LogrusHook{Redis: rdb})
 
-	// Start up the mail receiving task
+	// Initialize the Redis database driver
+	redisDriver := redisdb.NewDriver(rdb, config.Loaded.TokenHashingKey)
+
+	// Start up the event broker task
+	// It gets shut down right before the REST API so that open event streams do not block its shutdown
+	broker := events.NewBroker(rdb, config.Loaded.EventsRedisChannel)
+	brokerCtx, cancelBroker := context.WithCancel(context.Background())
+	go broker.Run(brokerCtx)
+
+	// Start up the mail receiving tasks
+	// Both the Pub/Sub and the Streams based receiver can be disabled by configuring an empty channel or stream name
+	processor := &mails.Processor{
+		Mailboxes:   driver.Mailboxes,
+		Messages:    driver.Messages,
+		Attachments: driver.Attachments,
+		DeadLetters: redisDriver.DeadLetters,
+		Events:      broker,
+
+		SubaddressDelimiters: config.Loaded.SubaddressDelimiters,
+	}
 	ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
-	go mails.Receiver(ctx, rdb.Subscribe(ctx, config.Loaded.MailsRedisChannel), driver.Mailboxes, driver.Messages)
+	if config.Loaded.MailsRedisChannel != "" {
+		go mails.Receiver(ctx, rdb.Subscribe(ctx, config.Loaded.MailsRedisChannel), processor)
+	}
+	if config.Loaded.MailsRedisStream != "" {
+		if config.Loaded.MailsClaimIdleTime <= 0 {
+			logrus.Fatal("CANAL_MAILS_CLAIM_IDLE_TIME has to be greater than 0")
+		}
+		go mails.StreamReceiver(ctx, rdb, mails.StreamOptions{
+			Stream:    config.Loaded.MailsRedisStream,
+			Group:     config.Loaded.MailsConsumerGroup,
+			Consumer:  config.Loaded.MailsConsumerName,
+			ClaimIdle: config.Loaded.MailsClaimIdleTime,
+		}, processor)
+	}
 
-	// Set the pre-defined domains
-	if err := setDomains(rdb, config.Loaded.DomainOverride); err != nil {
+	// Import the pre-defined domains and fill the domain cache
+	if err := importDomains(driver.Domains, redisDriver.Domains, config.Loaded.DomainOverride); err != nil {
 		logrus.WithError(err).Fatal()
 	}
 
+	// Load the keys used to sign and verify access tokens
+	keys := signing.NewHMACKeySet(config.Loaded.AccessTokenSigningKey)
+	if config.Loaded.AccessTokenSigningKeyFile == "" {
+		// The HS256 key falls back to a random one which changes with every restart and differs between replicas
+		if _, ok := os.LookupEnv("CANAL_ACCESS_TOKEN_SIGNING_KEY"); !ok {
+			logrus.Warn("Neither CANAL_ACCESS_TOKEN_SIGNING_KEY_FILE nor CANAL_ACCESS_TOKEN_SIGNING_KEY is set; using a random signing key, so access tokens will be invalidated on restart and rejected by other instances")
+		}
+	} else {
+		keys, err = signing.LoadKeySet(config.Loaded.AccessTokenSigningKeyFile, config.Loaded.AccessTokenVerificationKeys)
+		if err != nil {
+			logrus.WithError(err).Fatal()
+		}
+	}
+
 	// Start up the REST API
 	restApi := &api.API{
 		Services: &api.Services{
@@ -72,6 +137,20 @@ func main() {
 			Invites:       driver.Invites,
 			Mailboxes:     driver.Mailboxes,
 			Messages:      driver.Messages,
+			Attachments:   driver.Attachments,
+			DeadLetters:   redisDriver.DeadLetters,
+			Members:       driver.Members,
+			Transfers:     driver.Transfers,
+			Tokens:        driver.Tokens,
+			TwoFactor:     driver.TwoFactor,
+			Domains:       driver.Domains,
+			DomainCache:   redisDriver.Domains,
+			Revocations:   redisDriver.Revocations,
+			Challenges:    redisDriver.Challenges,
+			Keys:          keys,
+			Resolver:      verification.NewResolver(config.Loaded.DNSResolverAddress),
+			Mails:         processor,
+			Events:        broker,
 			Redis:         rdb,
 		},
 	}
@@ -85,6 +164,7 @@ func main() {
 			logrus.WithError(err).Error()
 		}
 	}()
+	defer cancelBroker()
 
 	// Notify karen about the service startup and shutdown
 	if static.ApplicationMode == "PROD" {
@@ -137,18 +217,143 @@ func refreshTokenCleanup(ctx context.Context, service shared.RefreshTokenService
 	}
 }
 
-func setDomains(rdb *redis.Client, domains []string) error {
-	processed := make([]interface{}, len(domains))
-	for i := range processed {
-		processed[i] = strings.ToLower(domains[i])
+func mailboxExpiry(ctx context.Context, mailboxes shared.MailboxService, messages shared.MessageService, interval time.Duration) {
+	logrus.Info("Starting the mailbox expiry task")
+	delay := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Shutting down the mailbox expiry task")
+			return
+		case <-time.After(delay):
+			if delay == 0 {
+				delay = interval
+			}
+			expired, err := mailboxes.Expired()
+			if err != nil {
+				logrus.WithError(err).Error("Error while retrieving expired mailboxes")
+				break
+			}
+			deleted := 0
+			for _, mailbox := range expired {
+				if err := messages.DeleteInMailbox(mailbox.Address); err != nil {
+					logrus.WithError(err).Error("Error while deleting messages of expired mailbox")
+					continue
+				}
+				if err := mailboxes.Delete(mailbox.Address); err != nil {
+					logrus.WithError(err).Error("Error while deleting expired mailbox")
+					continue
+				}
+				deleted++
+			}
+			logrus.Infof("Deleted %d expired mailboxes", deleted)
+		}
 	}
-	if len(processed) > 0 {
-		if err := rdb.Del(context.Background(), static.DomainsRedisKey).Err(); err != nil {
+}
+
+func retentionSweeper(ctx context.Context, service shared.MessageService, fallback, interval time.Duration, batchSize int) {
+	logrus.Info("Starting the message retention sweeper task")
+	delay := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Shutting down the message retention sweeper task")
+			return
+		case <-time.After(delay):
+			if delay == 0 {
+				delay = interval
+			}
+
+			// Delete retained messages in batches until a batch is not filled up anymore
+			start := time.Now()
+			batches := 0
+			total := int64(0)
+			mailboxes := make(map[string]int64)
+			for ctx.Err() == nil {
+				deleted, err := service.DeleteRetained(fallback, batchSize)
+				if err != nil {
+					metrics.Add(metrics.RetentionSweepFailures, 1)
+					logrus.WithError(err).Error("Error while deleting retained messages")
+					break
+				}
+				batches++
+
+				amount := int64(0)
+				for mailbox, count := range deleted {
+					mailboxes[mailbox] += count
+					amount += count
+				}
+				total += amount
+
+				if amount < int64(batchSize) {
+					break
+				}
+			}
+
+			metrics.Add(metrics.RetentionSweeps, 1)
+			metrics.Add(metrics.RetentionDeletedMessages, total)
+			metrics.Add(metrics.RetentionAffectedMailboxes, int64(len(mailboxes)))
+			metrics.SetTime(metrics.RetentionLastSweep, start)
+
+			logrus.WithFields(logrus.Fields{
+				"batches":   batches,
+				"mailboxes": len(mailboxes),
+				"duration":  time.Since(start).String(),
+			}).Infof("Deleted %d messages exceeding their retention period", total)
+		}
+	}
+}
+
+// importDomains creates all missing pre-defined domains and fills the domain cache afterwards
+// If no domain exists yet, the domains of the cache are imported as well to migrate setups predating the domains table.
+func importDomains(domains shared.DomainService, cache shared.DomainCache, override []string) error {
+	existing, err := domains.Domains()
+	if err != nil {
+		return err
+	}
+
+	names := override
+	if len(existing) == 0 {
+		cached, err := cache.Domains()
+		if err != nil {
+			return err
+		}
+		names = append(cached, override...)
+	}
+
+	now := time.Now().Unix()
+	for _, name := range names {
+		name = strings.ToLower(name)
+
+		// Only create missing domains so that domains disabled using the API stay disabled
+		domain, err := domains.Domain(name)
+		if err != nil {
 			return err
 		}
-		if err := rdb.SAdd(context.Background(), static.DomainsRedisKey, processed...).Err(); err != nil {
+		if domain != nil {
+			continue
+		}
+
+		domain = &shared.Domain{
+			Name:     name,
+			Enabled:  true,
+			Public:   true,
+			Created:  now,
+			Verified: true,
+		}
+		if err := domains.CreateOrReplace(domain); err != nil {
 			return err
 		}
+		logrus.WithField("domain", name).Info("Imported pre-defined domain")
+	}
+
+	enabled, err := domains.Enabled()
+	if err != nil {
+		return err
+	}
+	enabledNames := make([]string, 0, len(enabled))
+	for _, domain := range enabled {
+		enabledNames = append(enabledNames, domain.Name)
 	}
-	return nil
+	return cache.Replace(enabledNames)
 }
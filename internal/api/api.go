@@ -10,9 +10,13 @@ import (
 	recov "github.com/gofiber/fiber/v2/middleware/recover"
 	v1 "github.com/poopmail/canalization/internal/api/v1"
 	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/events"
 	"github.com/poopmail/canalization/internal/karen"
+	"github.com/poopmail/canalization/internal/mails"
 	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/signing"
 	"github.com/poopmail/canalization/internal/static"
+	"github.com/poopmail/canalization/internal/verification"
 	"github.com/sirupsen/logrus"
 	"github.com/ztrue/tracerr"
 )
@@ -30,6 +34,20 @@ type Services struct {
 	Invites       shared.InviteService
 	Mailboxes     shared.MailboxService
 	Messages      shared.MessageService
+	Attachments   shared.AttachmentService
+	DeadLetters   shared.DeadLetterService
+	Members       shared.MailboxMemberService
+	Transfers     shared.MailboxTransferService
+	Tokens        shared.PersonalAccessTokenService
+	TwoFactor     shared.TwoFactorService
+	Domains       shared.DomainService
+	DomainCache   shared.DomainCache
+	Revocations   shared.RevocationService
+	Challenges    shared.TwoFactorChallengeService
+	Keys          *signing.KeySet
+	Resolver      verification.TXTResolver
+	Mails         *mails.Processor
+	Events        *events.Broker
 	Redis         *redis.Client
 }
 
@@ -52,6 +70,7 @@ func (api *API) Serve() error {
 		},
 		DisableKeepalive:      true,
 		DisableStartupMessage: static.Production,
+		ProxyHeader:           config.Loaded.APIProxyHeader,
 	})
 
 	// Include CORS response headers
@@ -92,6 +111,20 @@ func (api *API) Serve() error {
 		Invites:       api.Services.Invites,
 		Mailboxes:     api.Services.Mailboxes,
 		Messages:      api.Services.Messages,
+		Attachments:   api.Services.Attachments,
+		DeadLetters:   api.Services.DeadLetters,
+		Members:       api.Services.Members,
+		Transfers:     api.Services.Transfers,
+		Tokens:        api.Services.Tokens,
+		TwoFactor:     api.Services.TwoFactor,
+		Domains:       api.Services.Domains,
+		DomainCache:   api.Services.DomainCache,
+		Revocations:   api.Services.Revocations,
+		Challenges:    api.Services.Challenges,
+		Keys:          api.Services.Keys,
+		Resolver:      api.Services.Resolver,
+		Mails:         api.Services.Mails,
+		Events:        api.Services.Events,
 		Redis:         api.Services.Redis,
 	}).Route(app.Group("/v1"))
 
@@ -15,36 +15,13 @@ import (
 // MiddlewareInjectAccount handles account injection and authorization
 func (app *App) MiddlewareInjectAccount(handleAuth bool) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		value := ctx.Params("identifier")
 		claims := ctx.Locals("_claims").(*accessTokenClaims)
 
-		var account *shared.Account
-		var err error
-
-		// Call the corresponding account retrieving function depending on the given parameter
-		if strings.ToLower(value) == "@me" {
-			account, err = app.Accounts.Account(claims.ID)
-		} else if strings.HasPrefix(value, "@") {
-			value = strings.TrimPrefix(value, "@")
-
-			id, idErr := snowflake.ParseString(value)
-			if idErr != nil {
-				return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
-			}
-
-			account, err = app.Accounts.Account(id)
-		} else {
-			account, err = app.Accounts.AccountByUsername(value)
-		}
-
+		account, err := app.findAccount(ctx.Params("identifier"), claims)
 		if err != nil {
 			return err
 		}
 
-		if account == nil {
-			return fiber.NewError(fiber.StatusNotFound, "account not found")
-		}
-
 		if handleAuth && claims.ID != account.ID && !claims.Admin {
 			return fiber.ErrForbidden
 		}
@@ -54,6 +31,39 @@ func (app *App) MiddlewareInjectAccount(handleAuth bool) fiber.Handler {
 	}
 }
 
+// findAccount retrieves the account referenced by the given identifier
+// The identifier may be '@me', '@' followed by a snowflake ID or a username
+func (app *App) findAccount(value string, claims *accessTokenClaims) (*shared.Account, error) {
+	var account *shared.Account
+	var err error
+
+	// Call the corresponding account retrieving function depending on the given parameter
+	if strings.ToLower(value) == "@me" {
+		account, err = app.Accounts.Account(claims.ID)
+	} else if strings.HasPrefix(value, "@") {
+		value = strings.TrimPrefix(value, "@")
+
+		id, idErr := snowflake.ParseString(value)
+		if idErr != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+		}
+
+		account, err = app.Accounts.Account(id)
+	} else {
+		account, err = app.Accounts.AccountByUsername(value)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if account == nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "account not found")
+	}
+
+	return account, nil
+}
+
 // EndpointGetAccounts handles the 'GET /v1/accounts' API endpoint
 func (app *App) EndpointGetAccounts(ctx *fiber.Ctx) error {
 	// Parse the 'skip' query parameter
@@ -163,8 +173,9 @@ func (app *App) EndpointCreateAccount(ctx *fiber.Ctx) error {
 }
 
 type endpointPatchAccountRequestBody struct {
-	Password string `json:"password"`
-	Admin    *bool  `json:"admin"`
+	Password  string        `json:"password"`
+	Admin     *bool         `json:"admin"`
+	Retention optionalInt64 `json:"retention"`
 }
 
 // EndpointPatchAccount handles the 'PATCH /v1/accounts/:identifier' API endpoint
@@ -175,10 +186,13 @@ func (app *App) EndpointPatchAccount(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	// Check if the executor is an admin if the admin field should be changed
-	if body.Admin != nil && !ctx.Locals("_claims").(*accessTokenClaims).Admin {
+	// Check if the executor is an admin if the admin or retention field should be changed
+	if (body.Admin != nil || body.Retention.Set) && !ctx.Locals("_claims").(*accessTokenClaims).Admin {
 		return fiber.ErrForbidden
 	}
+	if body.Retention.Value != nil && *body.Retention.Value < 0 {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid retention period")
+	}
 
 	// Update the account
 	account := ctx.Locals("_account").(*shared.Account)
@@ -189,9 +203,16 @@ func (app *App) EndpointPatchAccount(ctx *fiber.Ctx) error {
 		}
 		account.Password = hash
 	}
-	if body.Admin != nil {
+	if body.Admin != nil && *body.Admin != account.Admin {
+		// Revoke all access tokens as they still carry the old admin state
+		if err := app.revokeAccountAccessTokens(account.ID); err != nil {
+			return err
+		}
 		account.Admin = *body.Admin
 	}
+	if body.Retention.Set {
+		account.Retention = body.Retention.Value
+	}
 	if err := app.Accounts.CreateOrReplace(account); err != nil {
 		return err
 	}
@@ -225,19 +246,61 @@ func (app *App) EndpointDeleteAccount(ctx *fiber.Ctx) error {
 		return err
 	}
 
+	// Release all domains owned by the account so that they can be claimed again
+	if err := app.releaseAccountDomains(account.ID); err != nil {
+		return err
+	}
+
+	// Delete all mailbox memberships and pending mailbox transfers of the account
+	if err := app.Members.DeleteInAccount(account.ID); err != nil {
+		return err
+	}
+	if err := app.Transfers.DeleteInAccount(account.ID); err != nil {
+		return err
+	}
+
 	// Delete all refresh tokens of the account
 	if err := app.RefreshTokens.DeleteAll(account.ID); err != nil {
 		return err
 	}
 
-	// Delete the account
-	return app.Accounts.Delete(account.ID)
+	// Delete all personal access tokens of the account
+	if err := app.Tokens.DeleteAll(account.ID); err != nil {
+		return err
+	}
+
+	// Delete the two-factor authentication configuration and recovery codes of the account
+	if err := app.TwoFactor.Delete(account.ID); err != nil {
+		return err
+	}
+
+	// Delete the account and revoke all of its access tokens
+	if err := app.Accounts.Delete(account.ID); err != nil {
+		return err
+	}
+	return app.revokeAccountAccessTokens(account.ID)
 }
 
 // ######################
 // ### REFRESH TOKENS ###
 // ######################
 
+// refreshTokenResponse represents a refresh token without its token hash marked whether it belongs to the calling session
+type refreshTokenResponse struct {
+	shared.RefreshToken
+	Current bool `json:"current"`
+}
+
+// buildRefreshTokenResponse removes the token hash from the given refresh token and marks it if it belongs to the session of the given claims
+func buildRefreshTokenResponse(claims *accessTokenClaims, refreshToken *shared.RefreshToken) *refreshTokenResponse {
+	response := &refreshTokenResponse{
+		RefreshToken: *refreshToken,
+		Current:      claims.Session != 0 && claims.Session == refreshToken.Family,
+	}
+	response.Token = ""
+	return response
+}
+
 // MiddlewareInjectRefreshToken handles refresh token injection
 func (app *App) MiddlewareInjectRefreshToken(ctx *fiber.Ctx) error {
 	// Parse the snowflake ID of the refresh token
@@ -291,11 +354,10 @@ func (app *App) EndpointGetAccountRefreshTokens(ctx *fiber.Ctx) error {
 	}
 
 	// Remove the tokens from all retrieved refresh tokens
-	processed := make([]shared.RefreshToken, 0, len(refreshTokens))
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	processed := make([]*refreshTokenResponse, 0, len(refreshTokens))
 	for _, refreshToken := range refreshTokens {
-		copy := *refreshToken
-		copy.Token = ""
-		processed = append(processed, copy)
+		processed = append(processed, buildRefreshTokenResponse(claims, refreshToken))
 	}
 
 	return ctx.JSON(newPaginatedResponse(processed, count, len(processed)))
@@ -303,9 +365,8 @@ func (app *App) EndpointGetAccountRefreshTokens(ctx *fiber.Ctx) error {
 
 // EndpointGetAccountRefreshToken handles the 'GET /v1/accounts/:identifier/refresh_tokens/:id' API endpoint
 func (app *App) EndpointGetAccountRefreshToken(ctx *fiber.Ctx) error {
-	copy := *ctx.Locals("_refresh_token").(*shared.RefreshToken)
-	copy.Token = ""
-	return ctx.JSON(copy)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	return ctx.JSON(buildRefreshTokenResponse(claims, ctx.Locals("_refresh_token").(*shared.RefreshToken)))
 }
 
 type endpointPatchAccountRefreshTokenRequestBody struct {
@@ -330,9 +391,8 @@ func (app *App) EndpointPatchAccountRefreshToken(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	copy := *refreshToken
-	copy.Token = ""
-	return ctx.JSON(copy)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	return ctx.JSON(buildRefreshTokenResponse(claims, refreshToken))
 }
 
 // EndpointDeleteAccountRefreshToken handles the 'DELETE /v1/accounts/:identifier/refresh_tokens/:id' API endpoint
@@ -356,10 +416,13 @@ func (app *App) EndpointDeleteAccountRefreshToken(ctx *fiber.Ctx) error {
 			return fiber.NewError(fiber.StatusNotFound, "refresh token not found")
 		}
 
-		// Delete the refresh token
-		return app.RefreshTokens.Delete(account.ID, id)
+		// Revoke the session of the refresh token
+		return app.revokeSession(found.Family)
 	}
 
-	// Delete all refresh tokens
-	return app.RefreshTokens.DeleteAll(account.ID)
+	// Delete all refresh tokens and revoke all access tokens issued with them
+	if err := app.RefreshTokens.DeleteAll(account.ID); err != nil {
+		return err
+	}
+	return app.revokeAccountAccessTokens(account.ID)
 }
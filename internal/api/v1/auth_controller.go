@@ -2,6 +2,7 @@ package v1
 
 import (
 	"encoding/base64"
+	"fmt"
 	"strings"
 	"time"
 
@@ -11,8 +12,20 @@ import (
 	"github.com/poopmail/canalization/internal/config"
 	"github.com/poopmail/canalization/internal/hashing"
 	"github.com/poopmail/canalization/internal/id"
+	"github.com/poopmail/canalization/internal/karen"
 	"github.com/poopmail/canalization/internal/random"
 	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/static"
+	"github.com/poopmail/canalization/internal/useragent"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// refreshTokenSecretLength represents the amount of random bytes the secret of a refresh token consists of
+	refreshTokenSecretLength = 32
+
+	// legacyRefreshTokenHashPrefix represents the prefix of refresh token hashes created before refresh tokens were looked up by their ID
+	legacyRefreshTokenHashPrefix = "$argon2id$"
 )
 
 // MiddlewareHandleBasicAuth handles basic access token validation
@@ -22,7 +35,25 @@ func (app *App) MiddlewareHandleBasicAuth(ctx *fiber.Ctx) error {
 		return fiber.ErrUnauthorized
 	}
 
-	valid, claims, _ := app.processAccessToken(header[1])
+	var valid bool
+	var claims *accessTokenClaims
+	if strings.HasPrefix(header[1], personalAccessTokenPrefix) {
+		var err error
+		valid, claims, err = app.processPersonalAccessToken(header[1])
+		if err != nil {
+			return err
+		}
+	} else {
+		valid, claims, _ = app.processAccessToken(header[1])
+		if valid {
+			// Check if the access token has been revoked before its expiry
+			revoked, err := app.Revocations.Revoked(claims.Id, claims.Session, claims.ID, accessTokenIssuedAt(claims))
+			if err != nil {
+				return err
+			}
+			valid = !revoked
+		}
+	}
 	if !valid {
 		return fiber.ErrUnauthorized
 	}
@@ -69,33 +100,40 @@ func (app *App) EndpointPostRefreshToken(ctx *fiber.Ctx) error {
 		return fiber.ErrUnauthorized
 	}
 
-	// Generate and create a new refresh token
-	rawToken := random.RandomString(64)
-	hashedToken, err := hashing.Hash(rawToken)
+	// Require a second factor if the account has two-factor authentication enabled
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
 	if err != nil {
 		return err
 	}
+	if twoFactor != nil && twoFactor.Confirmed {
+		return app.createTwoFactorChallenge(ctx, account)
+	}
+
+	return app.createSession(ctx, account)
+}
+
+// createSession creates a new refresh token for the given account and sets it on the clients side
+func (app *App) createSession(ctx *fiber.Ctx, account *shared.Account) error {
+	// Generate and create a new refresh token
+	secret := random.SecureHex(refreshTokenSecretLength)
 	token := &shared.RefreshToken{
 		ID:          id.Generate(),
 		Account:     account.ID,
-		Token:       hashedToken,
+		Token:       hashing.HashToken(config.Loaded.TokenHashingKey, secret),
 		Description: "",
 		Created:     time.Now().Unix(),
+		CreatedIP:   ctx.IP(),
+		UserAgent:   useragent.Truncate(ctx.Get(fiber.HeaderUserAgent)),
+		Device:      useragent.Describe(ctx.Get(fiber.HeaderUserAgent)),
 	}
+	token.Family = token.ID
+	token.FamilyCreated = token.Created
 	if err := app.RefreshTokens.CreateOrReplace(token); err != nil {
 		return err
 	}
 
 	// Set the cookie on the clients side
-	ctx.Cookie(&fiber.Cookie{
-		Name:     "_refresh_token",
-		Value:    base64.StdEncoding.EncodeToString([]byte(account.ID.String() + ":" + rawToken)),
-		Path:     "/v1/auth/access_token",
-		Expires:  time.Now().Add(config.Loaded.RefreshTokenLifetime),
-		Secure:   true,
-		HTTPOnly: true,
-		SameSite: "Strict",
-	})
+	setRefreshTokenCookie(ctx, token, secret)
 	return ctx.SendStatus(fiber.StatusOK)
 }
 
@@ -107,41 +145,186 @@ func (app *App) EndpointGetAccessToken(ctx *fiber.Ctx) error {
 		return fiber.ErrUnauthorized
 	}
 
-	// Try to decode the value present in the refresh token cookie
-	decoded, err := base64.StdEncoding.DecodeString(refreshTokenValue)
+	// Retrieve the refresh token the cookie refers to
+	var refreshToken *shared.RefreshToken
+	var err error
+	if strings.Contains(refreshTokenValue, ".") {
+		refreshToken, err = app.lookupRefreshToken(refreshTokenValue)
+	} else {
+		refreshToken, err = app.lookupLegacyRefreshToken(refreshTokenValue)
+	}
 	if err != nil {
+		return err
+	}
+	if refreshToken == nil {
 		return fiber.ErrUnauthorized
 	}
 
-	split := strings.SplitN(string(decoded), ":", 2)
+	// A token which has already been consumed before was most likely stolen
+	if refreshToken.Consumed != nil {
+		return app.handleConsumedRefreshToken(ctx, refreshToken)
+	}
 
-	// Try to retrieve the account mentioned in the cookie
-	accountID, err := snowflake.ParseString(split[0])
+	// Retrieve the account the refresh token belongs to
+	account, err := app.Accounts.Account(refreshToken.Account)
 	if err != nil {
+		return err
+	}
+	if account == nil {
 		return fiber.ErrUnauthorized
 	}
-	account, err := app.Accounts.Account(accountID)
+
+	// Rotate the refresh token while keeping track of its last usage
+	secret := random.SecureHex(refreshTokenSecretLength)
+	now := time.Now().Unix()
+	rotated := &shared.RefreshToken{
+		ID:            id.Generate(),
+		Account:       account.ID,
+		Token:         hashing.HashToken(config.Loaded.TokenHashingKey, secret),
+		Description:   refreshToken.Description,
+		Created:       now,
+		Family:        refreshToken.Family,
+		FamilyCreated: refreshToken.FamilyCreated,
+		CreatedIP:     refreshToken.CreatedIP,
+		UserAgent:     refreshToken.UserAgent,
+		Device:        refreshToken.Device,
+		LastUsed:      &now,
+		LastUsedIP:    ctx.IP(),
+	}
+	rotatedNow, err := app.RefreshTokens.Rotate(refreshToken.ID, now, rotated)
 	if err != nil {
 		return err
 	}
-	if account == nil {
-		return fiber.ErrUnauthorized
+	if !rotatedNow {
+		// The refresh token got consumed concurrently; reload it to find out when
+		refreshToken, err = app.RefreshTokens.RefreshTokenByID(refreshToken.ID)
+		if err != nil {
+			return err
+		}
+		if refreshToken == nil || refreshToken.Consumed == nil {
+			return fiber.ErrUnauthorized
+		}
+		return app.handleConsumedRefreshToken(ctx, refreshToken)
+	}
+	setRefreshTokenCookie(ctx, rotated, secret)
+
+	// Issue a new access token
+	expires := time.Now().Add(config.Loaded.AccessTokenLifetime).Unix()
+	accessToken, err := app.issueAccessToken(account, rotated.Family, expires)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(fiber.Map{
+		"access_token": accessToken,
+		"expires":      expires,
+	})
+}
+
+// EndpointGetJWKS handles the 'GET /v1/auth/jwks.json' API endpoint
+// It exposes the public keys needed by other services to verify access tokens
+func (app *App) EndpointGetJWKS(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return ctx.JSON(app.Keys.JWKS())
+}
+
+// EndpointPostLogout handles the 'POST /v1/auth/logout' API endpoint
+// It revokes the session the access token has been issued for as well as the access token itself
+func (app *App) EndpointPostLogout(ctx *fiber.Ctx) error {
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	if claims.Session == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "access token does not belong to a session")
+	}
+
+	// Revoke the session
+	if err := app.revokeSession(claims.Session); err != nil {
+		return err
+	}
+
+	// Revoke the access token itself as it may have been issued before the session was tracked
+	if claims.Id != "" {
+		if err := app.Revocations.RevokeToken(claims.Id, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+			return err
+		}
+	}
+
+	clearRefreshTokenCookie(ctx)
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// revokeSession deletes all refresh tokens of the given session and revokes all access tokens issued for it
+func (app *App) revokeSession(session snowflake.ID) error {
+	if _, err := app.RefreshTokens.DeleteFamily(session); err != nil {
+		return err
+	}
+	return app.Revocations.RevokeSession(session, config.Loaded.AccessTokenLifetime)
+}
+
+// revokeAccountAccessTokens revokes all access tokens which have been issued for the given account until now
+func (app *App) revokeAccountAccessTokens(account snowflake.ID) error {
+	return app.Revocations.RevokeAccount(account, time.Now().UnixNano()/int64(time.Millisecond), config.Loaded.AccessTokenLifetime)
+}
+
+// accessTokenIssuedAt determines the issue time of an access token in unix milliseconds
+// The 'iat' claim only has a precision of seconds, so the time is taken from the snowflake ID of the token instead
+func accessTokenIssuedAt(claims *accessTokenClaims) int64 {
+	if jti, err := snowflake.ParseString(claims.Id); err == nil {
+		return jti.Time()
+	}
+	return claims.IssuedAt * 1000
+}
+
+// lookupRefreshToken retrieves the refresh token referenced by a '<token id>.<secret>' cookie value and validates its secret
+func (app *App) lookupRefreshToken(value string) (*shared.RefreshToken, error) {
+	split := strings.SplitN(value, ".", 2)
+	tokenID, err := snowflake.ParseString(split[0])
+	if err != nil {
+		return nil, nil
+	}
+
+	refreshToken, err := app.RefreshTokens.RefreshTokenByID(tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == nil || isRefreshTokenExpired(refreshToken) || !hashing.CheckToken(config.Loaded.TokenHashingKey, split[1], refreshToken.Token) {
+		return nil, nil
+	}
+	return refreshToken, nil
+}
+
+// lookupLegacyRefreshToken validates a legacy 'base64(<account id>:<secret>)' cookie value by comparing it to every
+// argon2id hashed refresh token of the account
+// The matching refresh token gets rotated into one in the current format like any other refresh token.
+// This can be removed once all legacy refresh tokens have expired.
+func (app *App) lookupLegacyRefreshToken(value string) (*shared.RefreshToken, error) {
+	// Try to decode the value present in the refresh token cookie
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil
+	}
+	split := strings.SplitN(string(decoded), ":", 2)
+	if len(split) != 2 {
+		return nil, nil
+	}
+
+	accountID, err := snowflake.ParseString(split[0])
+	if err != nil {
+		return nil, nil
 	}
 
 	// Retrieve all refresh tokens from that account
 	amount, err := app.RefreshTokens.Count(accountID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	refreshTokens, err := app.RefreshTokens.RefreshTokens(accountID, 0, amount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Loop through all these refresh tokens and compare them to the given one
+	// Loop through all legacy refresh tokens and compare them to the given one
 	var refreshToken *shared.RefreshToken
 	for _, potentialRefreshToken := range refreshTokens {
-		if potentialRefreshToken.Created < time.Now().Add(-config.Loaded.RefreshTokenLifetime).Unix() {
+		if isRefreshTokenExpired(potentialRefreshToken) || !strings.HasPrefix(potentialRefreshToken.Token, legacyRefreshTokenHashPrefix) {
 			continue
 		}
 
@@ -150,45 +333,111 @@ func (app *App) EndpointGetAccessToken(ctx *fiber.Ctx) error {
 			break
 		}
 	}
-	if refreshToken == nil {
-		return fiber.ErrUnauthorized
+	return refreshToken, nil
+}
+
+// handleConsumedRefreshToken handles a refresh token which has been presented after it got consumed
+// Tokens consumed only moments ago are most likely used by another tab of the same client racing the rotation,
+// so they are rejected without revoking the family and the client is expected to retry with the rotated token
+func (app *App) handleConsumedRefreshToken(ctx *fiber.Ctx, refreshToken *shared.RefreshToken) error {
+	if time.Since(time.Unix(*refreshToken.Consumed, 0)) <= config.Loaded.RefreshTokenReuseGrace {
+		return fiber.NewError(fiber.StatusConflict, "refresh token has just been rotated")
 	}
+	return app.handleRefreshTokenReuse(ctx, refreshToken)
+}
 
-	// Issue a new access token
-	expires := time.Now().Add(config.Loaded.AccessTokenLifetime).Unix()
-	accessToken, err := app.issueAccessToken(account, expires)
+// handleRefreshTokenReuse revokes the whole family of a refresh token which has been presented after it got consumed
+// and notifies karen about it
+func (app *App) handleRefreshTokenReuse(ctx *fiber.Ctx, refreshToken *shared.RefreshToken) error {
+	revoked, err := app.RefreshTokens.DeleteFamily(refreshToken.Family)
 	if err != nil {
 		return err
 	}
-	return ctx.JSON(fiber.Map{
-		"access_token": accessToken,
-		"expires":      expires,
+	if err := app.Revocations.RevokeSession(refreshToken.Family, config.Loaded.AccessTokenLifetime); err != nil {
+		return err
+	}
+
+	karenErr := karen.Send(app.Redis, karen.Message{
+		Type:    karen.MessageTypeWarning,
+		Service: static.KarenServiceName,
+		Topic:   "Refresh Token Reuse",
+		Description: fmt.Sprintf(
+			"consumed refresh token %s of account %s was presented again by %s; revoked %d refresh tokens of family %s",
+			refreshToken.ID, refreshToken.Account, ctx.IP(), revoked, refreshToken.Family,
+		),
 	})
+	if karenErr != nil {
+		logrus.WithError(karenErr).Error()
+	}
+
+	clearRefreshTokenCookie(ctx)
+	return fiber.ErrUnauthorized
+}
+
+// setRefreshTokenCookie sets the cookie carrying the given refresh token and its secret on the clients side
+func setRefreshTokenCookie(ctx *fiber.Ctx, token *shared.RefreshToken, secret string) {
+	ctx.Cookie(buildRefreshTokenCookie(token.ID.String()+"."+secret, time.Unix(token.FamilyCreated, 0).Add(config.Loaded.RefreshTokenLifetime)))
+}
+
+// clearRefreshTokenCookie removes the refresh token cookie from the clients side
+func clearRefreshTokenCookie(ctx *fiber.Ctx) {
+	ctx.Cookie(buildRefreshTokenCookie("", time.Unix(0, 0)))
+}
+
+func buildRefreshTokenCookie(value string, expires time.Time) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     "_refresh_token",
+		Value:    value,
+		Path:     "/v1/auth/access_token",
+		Expires:  expires,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: "Strict",
+	}
+}
+
+// isRefreshTokenExpired checks whether the family of the given refresh token has exceeded its lifetime
+// Rotating a refresh token does not extend the lifetime of its family
+func isRefreshTokenExpired(token *shared.RefreshToken) bool {
+	return token.FamilyCreated < time.Now().Add(-config.Loaded.RefreshTokenLifetime).Unix()
 }
 
 type accessTokenClaims struct {
 	jwt.StandardClaims
-	ID    snowflake.ID `json:"c_id"`
-	Admin bool         `json:"c_admin"`
+	ID     snowflake.ID `json:"c_id"`
+	Admin  bool         `json:"c_admin"`
+	Scopes []string     `json:"c_scopes,omitempty"`
+
+	// Session holds the family of the refresh token the access token has been issued with
+	Session snowflake.ID `json:"c_session,omitempty"`
 }
 
-func (app *App) issueAccessToken(account *shared.Account, expires int64) (string, error) {
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims{
+// hasScope checks whether the claims grant the given scope
+// Claims without any scopes originate from the refresh token flow and grant every scope
+func (claims *accessTokenClaims) hasScope(scope string) bool {
+	if claims.Scopes == nil {
+		return true
+	}
+	return hasScope(claims.Scopes, scope)
+}
+
+func (app *App) issueAccessToken(account *shared.Account, session snowflake.ID, expires int64) (string, error) {
+	return app.Keys.Sign(accessTokenClaims{
 		StandardClaims: jwt.StandardClaims{
+			Id:        id.Generate().String(),
 			ExpiresAt: expires,
 			IssuedAt:  time.Now().Unix(),
 			Subject:   account.ID.String(),
 		},
-		ID:    account.ID,
-		Admin: account.Admin,
-	}).SignedString(config.Loaded.AccessTokenSigningKey)
+		ID:      account.ID,
+		Admin:   account.Admin,
+		Session: session,
+	})
 }
 
 func (app *App) processAccessToken(token string) (bool, *accessTokenClaims, error) {
 	claims := new(accessTokenClaims)
-	parsed, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
-		return config.Loaded.AccessTokenSigningKey, nil
-	})
+	parsed, err := jwt.ParseWithClaims(token, claims, app.Keys.Keyfunc)
 	if err != nil {
 		return false, nil, err
 	}
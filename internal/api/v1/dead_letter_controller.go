@@ -0,0 +1,88 @@
+package v1
+
+import (
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/mails"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// MiddlewareInjectDeadLetter handles dead letter injection
+func (app *App) MiddlewareInjectDeadLetter(ctx *fiber.Ctx) error {
+	// Parse the snowflake ID of the dead letter
+	rawID := ctx.Params("id")
+	id, err := snowflake.ParseString(rawID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+	}
+
+	// Retrieve the dead letter
+	deadLetter, err := app.DeadLetters.DeadLetter(id)
+	if err != nil {
+		return err
+	}
+	if deadLetter == nil {
+		return fiber.NewError(fiber.StatusNotFound, "dead letter not found")
+	}
+
+	ctx.Locals("_dead_letter", deadLetter)
+	return ctx.Next()
+}
+
+// EndpointGetDeadLetters handles the 'GET /v1/admin/dead_letters' API endpoint
+func (app *App) EndpointGetDeadLetters(ctx *fiber.Ctx) error {
+	// Parse the 'skip' query parameter
+	skip, err := parseQueryInt("skip", 0, ctx)
+	if err != nil || skip < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	// Parse the 'limit' query parameter
+	limit, err := parseQueryInt("limit", 10, ctx)
+	if err != nil || limit < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	// Retrieve the total amount of dead letters
+	count, err := app.DeadLetters.Count()
+	if err != nil {
+		return err
+	}
+
+	// Retrieve the desired amount of dead letters
+	deadLetters, err := app.DeadLetters.DeadLetters(skip, limit)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newPaginatedResponse(deadLetters, count, len(deadLetters)))
+}
+
+// EndpointGetDeadLetter handles the 'GET /v1/admin/dead_letters/:id' API endpoint
+func (app *App) EndpointGetDeadLetter(ctx *fiber.Ctx) error {
+	return ctx.JSON(ctx.Locals("_dead_letter").(*shared.DeadLetter))
+}
+
+// EndpointRetryDeadLetter handles the 'POST /v1/admin/dead_letters/:id/retry' API endpoint
+func (app *App) EndpointRetryDeadLetter(ctx *fiber.Ctx) error {
+	deadLetter := ctx.Locals("_dead_letter").(*shared.DeadLetter)
+
+	// Process the payload again; the dead letter gets updated with the new error if this fails
+	if err := app.Mails.Retry(deadLetter); err != nil {
+		var processingErr *mails.ProcessingError
+		if !errors.As(err, &processingErr) {
+			return err
+		}
+		return ctx.Status(fiber.StatusUnprocessableEntity).JSON(deadLetter)
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// EndpointDeleteDeadLetter handles the 'DELETE /v1/admin/dead_letters/:id' API endpoint
+func (app *App) EndpointDeleteDeadLetter(ctx *fiber.Ctx) error {
+	deadLetter := ctx.Locals("_dead_letter").(*shared.DeadLetter)
+	return app.DeadLetters.Delete(deadLetter.ID)
+}
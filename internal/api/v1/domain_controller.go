@@ -0,0 +1,373 @@
+package v1
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/random"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/validation"
+	"github.com/poopmail/canalization/internal/verification"
+)
+
+const (
+	// verificationTokenLength represents the amount of random bytes the tokens used to verify the ownership of a domain consist of
+	verificationTokenLength = 16
+
+	// verificationTimeout represents the time after which looking up the verification TXT record of a domain is aborted
+	verificationTimeout = 10 * time.Second
+)
+
+// domainResponse represents a domain enriched with the instructions needed to verify its ownership
+type domainResponse struct {
+	*shared.Domain
+	Verification *domainVerificationResponse `json:"verification,omitempty"`
+}
+
+// domainVerificationResponse represents the TXT record an owner has to create to verify the ownership of a domain
+type domainVerificationResponse struct {
+	Record string `json:"record"`
+	Value  string `json:"value"`
+}
+
+// buildDomainResponses enriches the given domains with verification instructions if they are not verified yet
+func buildDomainResponses(domains ...*shared.Domain) []*domainResponse {
+	responses := make([]*domainResponse, 0, len(domains))
+	for _, domain := range domains {
+		response := &domainResponse{Domain: domain}
+		if !domain.Verified && domain.VerificationToken != "" {
+			response.Verification = &domainVerificationResponse{
+				Record: verification.RecordName(domain.Name),
+				Value:  verification.RecordValue(domain.VerificationToken),
+			}
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// MiddlewareInjectDomain handles domain injection
+// Non-admins can only see enabled public domains and the domains they own
+func (app *App) MiddlewareInjectDomain(ctx *fiber.Ctx) error {
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	domain, err := app.Domains.Domain(ctx.Params("name"))
+	if err != nil {
+		return err
+	}
+	if domain == nil || (!claims.Admin && !isDomainVisible(domain) && !isDomainOwner(domain, claims.ID)) {
+		return fiber.NewError(fiber.StatusNotFound, "domain not found")
+	}
+
+	ctx.Locals("_domain", domain)
+	return ctx.Next()
+}
+
+// EndpointGetDomains handles the 'GET /v1/domains' API endpoint
+func (app *App) EndpointGetDomains(ctx *fiber.Ctx) error {
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Admins see all domains
+	if claims.Admin {
+		domains, err := app.Domains.Domains()
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(buildDomainResponses(domains...))
+	}
+
+	// Retrieve all public domains and the ones owned by the executor
+	domains, err := app.Domains.Enabled()
+	if err != nil {
+		return err
+	}
+	owned, err := app.Domains.OwnedBy(claims.ID)
+	if err != nil {
+		return err
+	}
+
+	visible := make([]*shared.Domain, 0, len(domains)+len(owned))
+	for _, domain := range domains {
+		if isDomainVisible(domain) && !isDomainOwner(domain, claims.ID) {
+			visible = append(visible, domain)
+		}
+	}
+	visible = append(visible, owned...)
+
+	return ctx.JSON(buildDomainResponses(visible...))
+}
+
+// EndpointGetDomain handles the 'GET /v1/domains/:name' API endpoint
+func (app *App) EndpointGetDomain(ctx *fiber.Ctx) error {
+	return ctx.JSON(buildDomainResponses(ctx.Locals("_domain").(*shared.Domain))[0])
+}
+
+type endpointCreateDomainRequestBody struct {
+	Name    string `json:"name"`
+	Enabled *bool  `json:"enabled"`
+	Public  *bool  `json:"public"`
+}
+
+// EndpointCreateDomain handles the 'POST /v1/domains' API endpoint
+// Domains created by non-admins are restricted to their owner and stay disabled until their ownership got verified
+func (app *App) EndpointCreateDomain(ctx *fiber.Ctx) error {
+	// Try to parse the request into a request body struct
+	body := new(endpointCreateDomainRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Validate the domain name
+	name := strings.ToLower(strings.TrimSpace(body.Name))
+	if !validation.ValidateDomainName(name) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid domain name")
+	}
+
+	// Only admins may decide about the state of a domain
+	if !claims.Admin && (body.Enabled != nil || body.Public != nil) {
+		return fiber.ErrForbidden
+	}
+
+	// Check if the account has exceeded its domain limit
+	if !claims.Admin {
+		count, err := app.Domains.CountOwnedBy(claims.ID)
+		if err != nil {
+			return err
+		}
+		if count >= config.Loaded.AccountDomainLimit {
+			return fiber.NewError(fiber.StatusPreconditionFailed, "domain limit exceeded")
+		}
+	}
+
+	// Check if the domain already exists; unverified claims which have not been verified in time may be taken over so
+	// that nobody is able to block a domain by claiming it
+	found, err := app.Domains.Domain(name)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		if !isDomainClaimExpired(found) {
+			return fiber.NewError(fiber.StatusConflict, "domain already exists")
+		}
+		if err := app.Domains.Delete(found.Name); err != nil {
+			return err
+		}
+	}
+
+	// Create the domain
+	domain := &shared.Domain{
+		Name:     name,
+		Enabled:  body.Enabled == nil || *body.Enabled,
+		Public:   body.Public == nil || *body.Public,
+		Created:  time.Now().Unix(),
+		Verified: true,
+	}
+	if !claims.Admin {
+		owner := claims.ID
+		domain.Owner = &owner
+		domain.Enabled = false
+		domain.Public = false
+		domain.Verified = false
+		domain.VerificationToken = random.SecureHex(verificationTokenLength)
+	}
+	if err := app.Domains.CreateOrReplace(domain); err != nil {
+		return err
+	}
+	if err := app.syncDomains(); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(buildDomainResponses(domain)[0])
+}
+
+// EndpointVerifyDomain handles the 'POST /v1/domains/:name/verify' API endpoint
+func (app *App) EndpointVerifyDomain(ctx *fiber.Ctx) error {
+	domain := ctx.Locals("_domain").(*shared.Domain)
+
+	// Handle authorization
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	if !claims.Admin && !isDomainOwner(domain, claims.ID) {
+		return fiber.ErrForbidden
+	}
+
+	if domain.Verified {
+		return ctx.JSON(buildDomainResponses(domain)[0])
+	}
+
+	// Look up the verification TXT record of the domain
+	lookupCtx, cancel := context.WithTimeout(context.Background(), verificationTimeout)
+	defer cancel()
+	verified, err := verification.Verify(lookupCtx, app.Resolver, domain.Name, domain.VerificationToken)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, "verification record lookup failed")
+	}
+	if !verified {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "verification record not found")
+	}
+
+	// Enable the domain; the verification token is not needed anymore
+	domain.Verified = true
+	domain.Enabled = true
+	domain.VerificationToken = ""
+	if err := app.Domains.CreateOrReplace(domain); err != nil {
+		return err
+	}
+	if err := app.syncDomains(); err != nil {
+		return err
+	}
+
+	return ctx.JSON(buildDomainResponses(domain)[0])
+}
+
+type endpointPatchDomainRequestBody struct {
+	Enabled *bool `json:"enabled"`
+	Public  *bool `json:"public"`
+}
+
+// EndpointPatchDomain handles the 'PATCH /v1/domains/:name' API endpoint
+func (app *App) EndpointPatchDomain(ctx *fiber.Ctx) error {
+	domain := ctx.Locals("_domain").(*shared.Domain)
+
+	// Try to parse the request into a request body struct
+	body := new(endpointPatchDomainRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	// Update the domain
+	if body.Enabled != nil {
+		domain.Enabled = *body.Enabled
+	}
+	if body.Public != nil {
+		domain.Public = *body.Public
+	}
+	if err := app.Domains.CreateOrReplace(domain); err != nil {
+		return err
+	}
+	if err := app.syncDomains(); err != nil {
+		return err
+	}
+
+	return ctx.JSON(buildDomainResponses(domain)[0])
+}
+
+// EndpointDeleteDomain handles the 'DELETE /v1/domains/:name' API endpoint
+func (app *App) EndpointDeleteDomain(ctx *fiber.Ctx) error {
+	domain := ctx.Locals("_domain").(*shared.Domain)
+
+	// Handle authorization
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	if !claims.Admin && !isDomainOwner(domain, claims.ID) {
+		return fiber.ErrForbidden
+	}
+
+	// Refuse to delete domains which still have mailboxes unless forced to
+	if domain.Mailboxes > 0 {
+		force, err := parseQueryBool("force", ctx)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+		}
+		if force == nil || !*force {
+			return fiber.NewError(fiber.StatusPreconditionFailed, "domain still has mailboxes")
+		}
+
+		// Only admins may delete the mailboxes of other accounts
+		if !claims.Admin {
+			return fiber.ErrForbidden
+		}
+
+		// Delete all messages and mailboxes on the domain
+		if err := app.Messages.DeleteInDomain(domain.Name); err != nil {
+			return err
+		}
+		if err := app.Mailboxes.DeleteInDomain(domain.Name); err != nil {
+			return err
+		}
+	}
+
+	// Delete the domain itself
+	if err := app.Domains.Delete(domain.Name); err != nil {
+		return err
+	}
+	return app.syncDomains()
+}
+
+// releaseAccountDomains releases all domains owned by the given account whose mailboxes have already been deleted
+// Domains without mailboxes are deleted so that they can be claimed again; domains still holding mailboxes of other
+// accounts are handed over to the admins instead
+func (app *App) releaseAccountDomains(account snowflake.ID) error {
+	domains, err := app.Domains.OwnedBy(account)
+	if err != nil {
+		return err
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+
+	for _, domain := range domains {
+		if domain.Mailboxes == 0 {
+			if err := app.Domains.Delete(domain.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		domain.Owner = nil
+		domain.VerificationToken = ""
+		if err := app.Domains.CreateOrReplace(domain); err != nil {
+			return err
+		}
+	}
+	return app.syncDomains()
+}
+
+// syncDomains writes the names of all enabled domains into the domain cache read by the SMTP edge
+func (app *App) syncDomains() error {
+	domains, err := app.Domains.Enabled()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+	return app.DomainCache.Replace(names)
+}
+
+// isDomainVisible checks whether every account may see the given domain
+func isDomainVisible(domain *shared.Domain) bool {
+	return domain.Enabled && domain.Public
+}
+
+// isDomainClaimExpired checks whether the given domain is an unverified claim which has not been verified in time
+func isDomainClaimExpired(domain *shared.Domain) bool {
+	return !domain.Verified && domain.Owner != nil && domain.Created < time.Now().Add(-config.Loaded.DomainClaimLifetime).Unix()
+}
+
+// isDomainOwner checks whether the given domain is owned by the given account
+func isDomainOwner(domain *shared.Domain, accountID snowflake.ID) bool {
+	return domain.Owner != nil && *domain.Owner == accountID
+}
+
+// checkMailboxDomain checks whether a mailbox of the given account may be created on the domain with the given name
+// Restricted domains may only be used by admins and their owner
+func (app *App) checkMailboxDomain(claims *accessTokenClaims, account *shared.Account, name string) (*shared.Domain, error) {
+	domain, err := app.Domains.Domain(name)
+	if err != nil {
+		return nil, err
+	}
+	if domain == nil || !domain.Enabled {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox domain")
+	}
+	if !domain.Public && !claims.Admin && !isDomainOwner(domain, account.ID) {
+		return nil, fiber.ErrForbidden
+	}
+	return domain, nil
+}
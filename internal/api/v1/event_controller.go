@@ -0,0 +1,256 @@
+package v1
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/events"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+const (
+	// eventKeepAliveInterval represents the interval in which keep-alive frames are sent to event stream clients
+	eventKeepAliveInterval = 15 * time.Second
+
+	// eventAccessCheckInterval represents the interval in which the access of event stream clients to their mailbox is checked again
+	eventAccessCheckInterval = time.Minute
+)
+
+// MiddlewareAccessTokenFromQuery moves an access token given via the 'access_token' query parameter into the authorization header
+// This is needed for browser clients using EventSource or WebSocket as they are unable to set custom headers
+// Personal access tokens are rejected as they are long-lived and URLs tend to end up in logs and browser histories
+func (app *App) MiddlewareAccessTokenFromQuery(ctx *fiber.Ctx) error {
+	if token := ctx.Query("access_token"); token != "" && ctx.Get(fiber.HeaderAuthorization) == "" {
+		if strings.HasPrefix(token, personalAccessTokenPrefix) {
+			return fiber.NewError(fiber.StatusBadRequest, "personal access tokens may not be passed via the query")
+		}
+		ctx.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	return ctx.Next()
+}
+
+// EndpointGetMailboxEvents handles the 'GET /v1/mailboxes/:address/events' API endpoint
+// It streams all events of the mailbox as Server-Sent Events
+func (app *App) EndpointGetMailboxEvents(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	subscription, unsubscribe := app.Events.Subscribe(mailbox.Address)
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set("X-Accel-Buffering", "no")
+	ctx.Context().SetBodyStreamWriter(func(writer *bufio.Writer) {
+		defer unsubscribe()
+
+		ticker := time.NewTicker(eventKeepAliveInterval)
+		defer ticker.Stop()
+		accessTicker := time.NewTicker(eventAccessCheckInterval)
+		defer accessTicker.Stop()
+
+		// Send an initial comment so that the client knows the stream is open
+		fmt.Fprint(writer, ": connected\n\n")
+		if err := writer.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case event, ok := <-subscription:
+				if !ok {
+					return
+				}
+				encoded, err := json.Marshal(event)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.Type, encoded)
+			case <-ticker.C:
+				fmt.Fprint(writer, ": keep-alive\n\n")
+			case <-accessTicker.C:
+				if err := app.checkEventAccess(claims, mailbox.Address); err != nil {
+					return
+				}
+				continue
+			}
+
+			// A failing flush means that the client has disconnected
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// MiddlewareRequireWebSocketUpgrade rejects requests which do not want to upgrade to the WebSocket protocol
+func (app *App) MiddlewareRequireWebSocketUpgrade(ctx *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		return fiber.ErrUpgradeRequired
+	}
+	return ctx.Next()
+}
+
+// EndpointGetMailboxEventsWebSocket handles the 'GET /v1/mailboxes/:address/events/ws' API endpoint
+// It sends all events of the mailbox as JSON text messages over a WebSocket connection
+func (app *App) EndpointGetMailboxEventsWebSocket(ctx *fiber.Ctx) error {
+	return websocket.New(app.handleMailboxEventsWebSocket)(ctx)
+}
+
+func (app *App) handleMailboxEventsWebSocket(conn *websocket.Conn) {
+	mailbox := conn.Locals("_mailbox").(*shared.Mailbox)
+	claims := conn.Locals("_claims").(*accessTokenClaims)
+
+	subscription, unsubscribe := app.Events.Subscribe(mailbox.Address)
+	defer unsubscribe()
+
+	// Read (and discard) incoming messages to detect when the client disconnects
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(eventKeepAliveInterval)
+	defer ticker.Stop()
+	accessTicker := time.NewTicker(eventAccessCheckInterval)
+	defer accessTicker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-subscription:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventKeepAliveInterval)); err != nil {
+				return
+			}
+		case <-accessTicker.C:
+			if err := app.checkEventAccess(claims, mailbox.Address); err != nil {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "mailbox access revoked"))
+				return
+			}
+		}
+	}
+}
+
+// checkEventAccess checks whether the executor still has access to the mailbox whose events it subscribed to
+// Members may get removed and mailboxes may be transferred or deleted while an event stream is open
+func (app *App) checkEventAccess(claims *accessTokenClaims, address string) error {
+	mailbox, err := app.Mailboxes.Mailbox(address)
+	if err != nil {
+		return err
+	}
+	if mailbox == nil {
+		return fiber.NewError(fiber.StatusNotFound, "mailbox not found")
+	}
+	return app.checkMailboxAccess(claims, mailbox, mailboxAccessViewer)
+}
+
+// EndpointGetNextMailboxMessage handles the 'GET /v1/mailboxes/:address/messages/next' API endpoint
+// It blocks until a message newer than the one given via the 'since' query parameter matching the optional 'subject'
+// and 'from' query parameters arrives or the timeout is reached
+func (app *App) EndpointGetNextMailboxMessage(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+
+	// Parse the 'timeout' query parameter
+	timeout, err := parseQueryDuration("timeout", 30*time.Second, ctx)
+	if err != nil || timeout < 0 || timeout > config.Loaded.LongPollMaxTimeout {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	filter := &shared.MessageFilter{
+		Mailbox: mailbox.Address,
+		Subject: ctx.Query("subject"),
+		From:    ctx.Query("from"),
+		Tag:     ctx.Query("tag"),
+	}
+
+	// Parse the 'since' query parameter; only messages arriving from now on are considered if it is not set
+	if since := ctx.Query("since"); since != "" {
+		filter.After, err = snowflake.ParseString(since)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+		}
+	}
+
+	// Subscribe to the mailbox before looking into the database so that no message can slip through in between
+	subscription, unsubscribe := app.Events.Subscribe(mailbox.Address)
+	defer unsubscribe()
+
+	// Respond immediately if a matching message already exists
+	if filter.After != 0 {
+		messages, err := app.Messages.Messages(filter, 0, 1)
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			if err := app.injectAttachments(messages[0]); err != nil {
+				return err
+			}
+			return ctx.JSON(messages[0])
+		}
+	}
+
+	// Wait for a matching message to arrive
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return ctx.SendStatus(fiber.StatusNoContent)
+		case event, ok := <-subscription:
+			if !ok {
+				return fiber.ErrServiceUnavailable
+			}
+			if event.Type == events.TypeMessageCreated && matchesMessageFilter(event.Message, filter) {
+				// The event is shared between all subscribers, so work on a copy of its message
+				message := *event.Message
+				if message.Content != nil {
+					content := *message.Content
+					message.Content = &content
+				}
+				if err := app.injectAttachments(&message); err != nil {
+					return err
+				}
+				return ctx.JSON(&message)
+			}
+		}
+	}
+}
+
+// matchesMessageFilter checks whether the given message matches the 'after', 'subject', 'from' and 'tag' conditions of the given filter
+func matchesMessageFilter(message *shared.Message, filter *shared.MessageFilter) bool {
+	if message.ID <= filter.After {
+		return false
+	}
+	if filter.Subject != "" && !strings.Contains(strings.ToLower(message.Subject), strings.ToLower(filter.Subject)) {
+		return false
+	}
+	if filter.From != "" && (message.Headers == nil || message.Headers.From == nil || !strings.EqualFold(message.Headers.From.Address, filter.From)) {
+		return false
+	}
+	if filter.Tag != "" && !strings.EqualFold(message.Tag, filter.Tag) {
+		return false
+	}
+	return true
+}
@@ -1,8 +1,6 @@
 package v1
 
 import (
-	"context"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/poopmail/canalization/internal/static"
 )
@@ -14,12 +12,3 @@ func (app *App) EndpointGetInfo(ctx *fiber.Ctx) error {
 		"version":    static.ApplicationVersion,
 	})
 }
-
-// EndpointGetInfo handles the 'GET /v1/domains' API endpoint
-func (app *App) EndpointGetDomains(ctx *fiber.Ctx) error {
-	domains, err := app.Redis.SMembers(context.Background(), static.DomainsRedisKey).Result()
-	if err != nil {
-		return err
-	}
-	return ctx.JSON(domains)
-}
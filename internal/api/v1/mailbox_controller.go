@@ -1,19 +1,132 @@
 package v1
 
 import (
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/gofiber/fiber/v2"
 	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/random"
 	"github.com/poopmail/canalization/internal/shared"
-	"github.com/poopmail/canalization/internal/static"
 	"github.com/poopmail/canalization/internal/validation"
 )
 
+// mailboxResponse represents a mailbox enriched with information about its messages
+type mailboxResponse struct {
+	*shared.Mailbox
+	Unread             int                `json:"unread"`
+	EffectiveRetention *retentionResponse `json:"effective_retention,omitempty"`
+
+	// Role holds the role of the requested account if the mailbox got shared with it
+	Role shared.MailboxRole `json:"role,omitempty"`
+}
+
+// retentionResponse represents the retention period applying to the messages of a mailbox
+// A retention period of zero means that messages are kept forever
+type retentionResponse struct {
+	Seconds int64  `json:"seconds"`
+	Source  string `json:"source"`
+}
+
+// effectiveRetention determines the retention period applying to the messages of the given mailbox
+// The mailbox override takes precedence over the account override which takes precedence over the global default
+func (app *App) effectiveRetention(mailbox *shared.Mailbox) (*retentionResponse, error) {
+	if mailbox.Retention != nil {
+		return &retentionResponse{Seconds: *mailbox.Retention, Source: "mailbox"}, nil
+	}
+	return app.inheritedRetention(mailbox.Account)
+}
+
+// inheritedRetention determines the retention period mailboxes of the given account inherit
+func (app *App) inheritedRetention(accountID snowflake.ID) (*retentionResponse, error) {
+	account, err := app.Accounts.Account(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil && account.Retention != nil {
+		return &retentionResponse{Seconds: *account.Retention, Source: "account"}, nil
+	}
+	return &retentionResponse{Seconds: int64(config.Loaded.MessageRetention.Seconds()), Source: "default"}, nil
+}
+
+// buildMailboxResponses enriches the given mailboxes with the amount of their unread messages
+func (app *App) buildMailboxResponses(mailboxes ...*shared.Mailbox) ([]*mailboxResponse, error) {
+	addresses := make([]string, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		addresses = append(addresses, mailbox.Address)
+	}
+
+	unread, err := app.Messages.CountUnseen(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*mailboxResponse, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		responses = append(responses, &mailboxResponse{
+			Mailbox: mailbox,
+			Unread:  unread[mailbox.Address],
+		})
+	}
+	return responses, nil
+}
+
+// mailboxAccess represents the level of access an account has to a mailbox
+type mailboxAccess int
+
+const (
+	mailboxAccessNone mailboxAccess = iota
+	mailboxAccessViewer
+	mailboxAccessManager
+	mailboxAccessOwner
+)
+
+// resolveMailboxAccess determines the level of access the executor has to the given mailbox
+// Admins are treated like the owner of every mailbox
+func (app *App) resolveMailboxAccess(claims *accessTokenClaims, mailbox *shared.Mailbox) (mailboxAccess, error) {
+	if mailbox.Account == claims.ID || claims.Admin {
+		return mailboxAccessOwner, nil
+	}
+
+	member, err := app.Members.Member(mailbox.Address, claims.ID)
+	if err != nil {
+		return mailboxAccessNone, err
+	}
+	if member == nil {
+		return mailboxAccessNone, nil
+	}
+
+	switch member.Role {
+	case shared.MailboxRoleManager:
+		return mailboxAccessManager, nil
+	case shared.MailboxRoleViewer:
+		return mailboxAccessViewer, nil
+	default:
+		return mailboxAccessNone, nil
+	}
+}
+
+// checkMailboxAccess checks whether the executor has at least the given level of access to the given mailbox
+func (app *App) checkMailboxAccess(claims *accessTokenClaims, mailbox *shared.Mailbox, required mailboxAccess) error {
+	if required == mailboxAccessNone {
+		return nil
+	}
+
+	access, err := app.resolveMailboxAccess(claims, mailbox)
+	if err != nil {
+		return err
+	}
+	if access < required {
+		return fiber.ErrForbidden
+	}
+	return nil
+}
+
 // MiddlewareInjectMailbox handles mailbox injection
-func (app *App) MiddlewareInjectMailbox(handleAuth bool) fiber.Handler {
+// The executor needs at least the given level of access to the mailbox
+func (app *App) MiddlewareInjectMailbox(required mailboxAccess) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		claims := ctx.Locals("_claims").(*accessTokenClaims)
 
@@ -28,9 +141,9 @@ func (app *App) MiddlewareInjectMailbox(handleAuth bool) fiber.Handler {
 			return fiber.NewError(fiber.StatusNotFound, "mailbox not found")
 		}
 
-		// Handle authorization if needed
-		if handleAuth && mailbox.Account != claims.ID && !claims.Admin {
-			return fiber.ErrForbidden
+		// Handle authorization
+		if err := app.checkMailboxAccess(claims, mailbox, required); err != nil {
+			return err
 		}
 
 		ctx.Locals("_mailbox", mailbox)
@@ -121,6 +234,7 @@ func (app *App) EndpointGetMailboxes(ctx *fiber.Ctx) error {
 	// Retrieve the desired amount of mailboxes
 	var count int
 	var mailboxes []*shared.Mailbox
+	var roles map[string]shared.MailboxRole
 	if account == nil {
 		count, err = app.Mailboxes.Count()
 		if err != nil {
@@ -132,49 +246,63 @@ func (app *App) EndpointGetMailboxes(ctx *fiber.Ctx) error {
 			return err
 		}
 	} else {
-		count, err = app.Mailboxes.CountInAccount(account.ID)
+		count, err = app.Mailboxes.CountAccessible(account.ID)
 		if err != nil {
 			return err
 		}
 
-		mailboxes, err = app.Mailboxes.MailboxesInAccount(account.ID, skip, limit)
+		mailboxes, roles, err = app.Mailboxes.MailboxesAccessible(account.ID, skip, limit)
 		if err != nil {
 			return err
 		}
 	}
 
-	return ctx.JSON(newPaginatedResponse(mailboxes, count, len(mailboxes)))
+	responses, err := app.buildMailboxResponses(mailboxes...)
+	if err != nil {
+		return err
+	}
+
+	// Mark the mailboxes which got shared with the account
+	for _, response := range responses {
+		response.Role = roles[response.Address]
+	}
+
+	return ctx.JSON(newPaginatedResponse(responses, count, len(responses)))
 }
 
 // EndpointGetMailbox handles the 'GET /v1/mailboxes/:address' API endpoint
 func (app *App) EndpointGetMailbox(ctx *fiber.Ctx) error {
-	return ctx.JSON(ctx.Locals("_mailbox").(*shared.Mailbox))
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+
+	responses, err := app.buildMailboxResponses(mailbox)
+	if err != nil {
+		return err
+	}
+
+	// Report when the messages of the mailbox will be deleted
+	responses[0].EffectiveRetention, err = app.effectiveRetention(mailbox)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(responses[0])
 }
 
 type endpointCreateMailboxRequestBody struct {
 	Key     string `json:"key"`
 	Domain  string `json:"domain"`
 	Account string `json:"account"`
+	Expires *int64 `json:"expires"`
+	Pattern bool   `json:"pattern"`
 }
 
-// EndpointCreateMailbox handles the 'POST /v1/mailboxes' API endpoint
-func (app *App) EndpointCreateMailbox(ctx *fiber.Ctx) error {
-	// Try to parse the request into a request body struct
-	body := new(endpointCreateMailboxRequestBody)
-	if err := ctx.BodyParser(body); err != nil {
-		return err
-	}
-	if body.Key == "" || body.Domain == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "bad request body")
-	}
-
-	accountName := "@me"
-	if body.Account != "" {
-		accountName = strings.ToLower(body.Account)
+// retrieveMailboxAccount retrieves the account a mailbox should be created in and checks if the executor may do so
+func (app *App) retrieveMailboxAccount(claims *accessTokenClaims, accountName string) (*shared.Account, error) {
+	accountName = strings.ToLower(accountName)
+	if accountName == "" {
+		accountName = "@me"
 	}
 
-	claims := ctx.Locals("_claims").(*accessTokenClaims)
-
 	// Retrieve the account
 	var account *shared.Account
 	var err error
@@ -184,64 +312,306 @@ func (app *App) EndpointCreateMailbox(ctx *fiber.Ctx) error {
 		account, err = app.Accounts.AccountByUsername(accountName)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if account == nil {
-		return fiber.NewError(fiber.StatusNotFound, "account not found")
+		return nil, fiber.NewError(fiber.StatusNotFound, "account not found")
 	}
 
 	// Handle authorization
 	if account.ID != claims.ID && !claims.Admin {
-		return fiber.ErrForbidden
+		return nil, fiber.ErrForbidden
 	}
 
-	// Validate the mailbox key
-	if !validation.ValidateMailboxKey(body.Key) {
-		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox key")
+	return account, nil
+}
+
+// checkMailboxLimit checks if the given account has exceeded its mailbox limit
+// Admins may exceed the limit of other accounts
+func (app *App) checkMailboxLimit(claims *accessTokenClaims, account *shared.Account) error {
+	if claims.Admin {
+		return nil
+	}
+	return app.checkAccountMailboxLimit(account)
+}
+
+// checkAccountMailboxLimit checks if the given account has exceeded its mailbox limit regardless of the executor
+func (app *App) checkAccountMailboxLimit(account *shared.Account) error {
+	if account.Admin {
+		return nil
+	}
+
+	count, err := app.Mailboxes.CountInAccount(account.ID)
+	if err != nil {
+		return err
+	}
+	if count >= config.Loaded.AccountMailboxLimit {
+		return fiber.NewError(fiber.StatusPreconditionFailed, "mailbox limit exceeded")
+	}
+	return nil
+}
+
+// EndpointCreateMailbox handles the 'POST /v1/mailboxes' API endpoint
+func (app *App) EndpointCreateMailbox(ctx *fiber.Ctx) error {
+	// Try to parse the request into a request body struct
+	body := new(endpointCreateMailboxRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+	if body.Key == "" || body.Domain == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "bad request body")
+	}
+
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Retrieve the account the mailbox should be created in
+	account, err := app.retrieveMailboxAccount(claims, body.Account)
+	if err != nil {
+		return err
 	}
 
 	// Validate the mailbox domain
-	isValidDomain, err := app.Redis.SIsMember(ctx.Context(), static.DomainsRedisKey, strings.ToLower(body.Domain)).Result()
+	domain, err := app.checkMailboxDomain(claims, account, body.Domain)
 	if err != nil {
 		return err
 	}
-	if !isValidDomain {
-		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox domain")
+
+	// Validate the mailbox key
+	if body.Pattern {
+		if !validation.ValidateMailboxPattern(body.Key) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox pattern")
+		}
+
+		// Only admins and domain owners may catch large parts of the mails sent to a domain
+		if validation.IsPrivilegedPattern(body.Key) && !claims.Admin && !isDomainOwner(domain, account.ID) {
+			return fiber.ErrForbidden
+		}
+	} else if !validation.ValidateMailboxKey(body.Key) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox key")
+	}
+
+	// Validate the requested expiry date
+	expires, err := resolveMailboxExpiry(body.Expires, claims.Admin || account.Admin)
+	if err != nil {
+		return err
 	}
 
 	// Check if the account has exceeded its mailbox limit
-	if !claims.Admin && !account.Admin {
-		count, err := app.Mailboxes.CountInAccount(account.ID)
+	if err := app.checkMailboxLimit(claims, account); err != nil {
+		return err
+	}
+
+	// Create the mailbox if its address is not taken yet
+	mailbox := &shared.Mailbox{
+		Address: body.Key + "@" + body.Domain,
+		Account: account.ID,
+		Created: time.Now().Unix(),
+		Expires: expires,
+		Pattern: body.Pattern,
+	}
+	created, err := app.Mailboxes.Create(mailbox)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return fiber.NewError(fiber.StatusConflict, "mailbox address taken")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(mailbox)
+}
+
+type endpointCreateRandomMailboxRequestBody struct {
+	Domain   string             `json:"domain"`
+	Account  string             `json:"account"`
+	Strategy random.KeyStrategy `json:"strategy"`
+	Expires  *int64             `json:"expires"`
+}
+
+// randomMailboxAttempts represents the amount of times a random mailbox key is generated before giving up
+const randomMailboxAttempts = 10
+
+// EndpointCreateRandomMailbox handles the 'POST /v1/mailboxes/random' API endpoint
+func (app *App) EndpointCreateRandomMailbox(ctx *fiber.Ctx) error {
+	// Try to parse the request into a request body struct
+	// The body is optional so that one-click mailboxes can be created without any parameters
+	body := new(endpointCreateRandomMailboxRequestBody)
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(body); err != nil {
+			return err
+		}
+	}
+
+	strategy := body.Strategy
+	if strategy == "" {
+		strategy = random.KeyStrategy(config.Loaded.MailboxKeyStrategy)
+	}
+	if !random.IsValidKeyStrategy(strategy) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid key strategy")
+	}
+
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Retrieve the account the mailbox should be created in
+	account, err := app.retrieveMailboxAccount(claims, body.Account)
+	if err != nil {
+		return err
+	}
+
+	// Validate the requested domain or pick a random one
+	domain := strings.ToLower(body.Domain)
+	if domain != "" {
+		if _, err := app.checkMailboxDomain(claims, account, domain); err != nil {
+			return err
+		}
+	} else {
+		domains, err := app.Domains.Enabled()
 		if err != nil {
 			return err
 		}
 
-		if count >= config.Loaded.AccountMailboxLimit {
-			return fiber.NewError(fiber.StatusPreconditionFailed, "mailbox limit exceeded")
+		var candidates []string
+		for _, candidate := range domains {
+			if candidate.Public || claims.Admin || isDomainOwner(candidate, account.ID) {
+				candidates = append(candidates, candidate.Name)
+			}
+		}
+		if len(candidates) == 0 {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "no domain available")
 		}
+		domain = candidates[rand.Intn(len(candidates))]
 	}
 
-	// Check if the mailbox already exists
-	address := body.Key + "@" + body.Domain
-	found, err := app.Mailboxes.Mailbox(address)
+	// Validate the requested expiry date
+	expires, err := resolveMailboxExpiry(body.Expires, claims.Admin || account.Admin)
 	if err != nil {
 		return err
 	}
-	if found != nil {
-		return fiber.NewError(fiber.StatusConflict, "mailbox address taken")
+
+	// Check if the account has exceeded its mailbox limit
+	if err := app.checkMailboxLimit(claims, account); err != nil {
+		return err
 	}
 
-	// Create the mailbox
+	// Generate keys until one of them could be reserved
+	// Later attempts append a random number to the key to escape crowded key spaces
 	mailbox := &shared.Mailbox{
-		Address: address,
 		Account: account.ID,
 		Created: time.Now().Unix(),
+		Expires: expires,
+	}
+	for attempt := 0; attempt < randomMailboxAttempts; attempt++ {
+		mailbox.Address = random.MailboxKey(strategy, attempt >= randomMailboxAttempts/2) + "@" + domain
+		created, err := app.Mailboxes.Create(mailbox)
+		if err != nil {
+			return err
+		}
+		if created {
+			return ctx.Status(fiber.StatusCreated).JSON(mailbox)
+		}
+	}
+
+	return fiber.NewError(fiber.StatusConflict, "could not find an available mailbox address")
+}
+
+type endpointPatchMailboxRequestBody struct {
+	Expires   *int64        `json:"expires"`
+	Retention optionalInt64 `json:"retention"`
+}
+
+// EndpointPatchMailbox handles the 'PATCH /v1/mailboxes/:address' API endpoint
+func (app *App) EndpointPatchMailbox(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+
+	// Try to parse the request into a request body struct
+	body := new(endpointPatchMailboxRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
 	}
-	if err := app.Mailboxes.CreateOrReplace(mailbox); err != nil {
+
+	privileged, err := app.isPrivilegedFor(ctx.Locals("_claims").(*accessTokenClaims), mailbox.Account)
+	if err != nil {
 		return err
 	}
 
-	return ctx.Status(fiber.StatusCreated).JSON(mailbox)
+	// Update the expiry date of the mailbox
+	if body.Expires != nil {
+		expires, err := resolveMailboxExpiry(body.Expires, privileged)
+		if err != nil {
+			return err
+		}
+		mailbox.Expires = expires
+	}
+
+	// Update the retention override of the mailbox
+	// Non-admins may only shorten the retention period their mailbox would inherit
+	if body.Retention.Set {
+		if value := body.Retention.Value; value != nil {
+			if *value < 0 {
+				return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid retention period")
+			}
+
+			inherited, err := app.inheritedRetention(mailbox.Account)
+			if err != nil {
+				return err
+			}
+			if !privileged && inherited.Seconds > 0 && (*value == 0 || *value > inherited.Seconds) {
+				return fiber.NewError(fiber.StatusUnprocessableEntity, "retention period exceeds the inherited one")
+			}
+		}
+		mailbox.Retention = body.Retention.Value
+	}
+
+	if err := app.Mailboxes.UpdateSettings(mailbox); err != nil {
+		return err
+	}
+
+	responses, err := app.buildMailboxResponses(mailbox)
+	if err != nil {
+		return err
+	}
+	responses[0].EffectiveRetention, err = app.effectiveRetention(mailbox)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(responses[0])
+}
+
+// isPrivilegedFor checks whether the executor or the given account is an admin and thus not bound to any limits
+func (app *App) isPrivilegedFor(claims *accessTokenClaims, accountID snowflake.ID) (bool, error) {
+	if claims.Admin {
+		return true, nil
+	}
+
+	account, err := app.Accounts.Account(accountID)
+	if err != nil {
+		return false, err
+	}
+	return account != nil && account.Admin, nil
+}
+
+// resolveMailboxExpiry validates the requested expiry date of a mailbox and applies the configured maximum TTL
+// A requested expiry date of zero means that the mailbox should never expire
+func resolveMailboxExpiry(requested *int64, privileged bool) (*int64, error) {
+	now := time.Now()
+	limited := !privileged && config.Loaded.MailboxMaxTTL > 0
+	maximum := now.Add(config.Loaded.MailboxMaxTTL).Unix()
+
+	if requested == nil || *requested == 0 {
+		if limited {
+			return &maximum, nil
+		}
+		return nil, nil
+	}
+
+	if *requested <= now.Unix() {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "expiry date lies in the past")
+	}
+	if limited && *requested > maximum {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "expiry date exceeds the maximum mailbox lifetime")
+	}
+
+	expires := *requested
+	return &expires, nil
 }
 
 // EndpointDeleteMailbox handles the 'DELETE /v1/mailboxes/:address' API endpoint
@@ -0,0 +1,98 @@
+package v1
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// EndpointGetMailboxMembers handles the 'GET /v1/mailboxes/:address/members' API endpoint
+func (app *App) EndpointGetMailboxMembers(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+
+	members, err := app.Members.Members(mailbox.Address)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(members)
+}
+
+type endpointCreateMailboxMemberRequestBody struct {
+	Account string             `json:"account"`
+	Role    shared.MailboxRole `json:"role"`
+}
+
+// EndpointCreateMailboxMember handles the 'POST /v1/mailboxes/:address/members' API endpoint
+// An existing member gets its role replaced
+func (app *App) EndpointCreateMailboxMember(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+
+	// Try to parse the request into a request body struct
+	body := new(endpointCreateMailboxMemberRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+	if body.Account == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "bad request body")
+	}
+	if body.Role == "" {
+		body.Role = shared.MailboxRoleViewer
+	}
+	if body.Role != shared.MailboxRoleViewer && body.Role != shared.MailboxRoleManager {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid mailbox role")
+	}
+
+	// Retrieve the account the mailbox should be shared with
+	account, err := app.findAccount(body.Account, ctx.Locals("_claims").(*accessTokenClaims))
+	if err != nil {
+		return err
+	}
+	if account.ID == mailbox.Account {
+		return fiber.NewError(fiber.StatusConflict, "account owns the mailbox")
+	}
+
+	// Create the member
+	member := &shared.MailboxMember{
+		Mailbox: mailbox.Address,
+		Account: account.ID,
+		Role:    body.Role,
+		Created: time.Now().Unix(),
+	}
+	if err := app.Members.CreateOrReplace(member); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(member)
+}
+
+// EndpointDeleteMailboxMember handles the 'DELETE /v1/mailboxes/:address/members/:identifier' API endpoint
+// Members may always remove themselves while removing others requires manager access
+func (app *App) EndpointDeleteMailboxMember(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Retrieve the account which should be removed
+	account, err := app.findAccount(ctx.Params("identifier"), claims)
+	if err != nil {
+		return err
+	}
+
+	// Handle authorization
+	if account.ID != claims.ID {
+		if err := app.checkMailboxAccess(claims, mailbox, mailboxAccessManager); err != nil {
+			return err
+		}
+	}
+
+	// Retrieve the member
+	member, err := app.Members.Member(mailbox.Address, account.ID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return fiber.NewError(fiber.StatusNotFound, "member not found")
+	}
+
+	return app.Members.Delete(member.Mailbox, member.Account)
+}
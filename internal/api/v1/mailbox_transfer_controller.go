@@ -0,0 +1,184 @@
+package v1
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/validation"
+)
+
+type endpointCreateMailboxTransferRequestBody struct {
+	Account string `json:"account"`
+	Force   bool   `json:"force"`
+}
+
+// EndpointCreateMailboxTransfer handles the 'POST /v1/mailboxes/:address/transfer' API endpoint
+// The transfer stays pending until the receiving account accepts it unless an admin forces it
+func (app *App) EndpointCreateMailboxTransfer(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Try to parse the request into a request body struct
+	body := new(endpointCreateMailboxTransferRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+	if body.Account == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "bad request body")
+	}
+	if body.Force && !claims.Admin {
+		return fiber.ErrForbidden
+	}
+
+	// Retrieve the receiving account
+	account, err := app.findAccount(body.Account, claims)
+	if err != nil {
+		return err
+	}
+	if account.ID == mailbox.Account {
+		return fiber.NewError(fiber.StatusConflict, "account already owns the mailbox")
+	}
+
+	// Transfer the mailbox right away if the transfer is forced
+	if body.Force {
+		if err := app.transferMailbox(claims, mailbox, account); err != nil {
+			return err
+		}
+		return ctx.JSON(mailbox)
+	}
+
+	// Create the pending transfer
+	transfer := &shared.MailboxTransfer{
+		Mailbox: mailbox.Address,
+		From:    mailbox.Account,
+		To:      account.ID,
+		Created: time.Now().Unix(),
+	}
+	if err := app.Transfers.CreateOrReplace(transfer); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(transfer)
+}
+
+// EndpointGetMailboxTransfer handles the 'GET /v1/mailboxes/:address/transfer' API endpoint
+func (app *App) EndpointGetMailboxTransfer(ctx *fiber.Ctx) error {
+	transfer, err := app.retrieveMailboxTransfer(ctx, true)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(transfer)
+}
+
+// EndpointAcceptMailboxTransfer handles the 'POST /v1/mailboxes/:address/transfer/accept' API endpoint
+func (app *App) EndpointAcceptMailboxTransfer(ctx *fiber.Ctx) error {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	transfer, err := app.retrieveMailboxTransfer(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	// Drop the transfer if the mailbox changed its owner in the meantime
+	if transfer.From != mailbox.Account {
+		if err := app.Transfers.Delete(transfer.Mailbox); err != nil {
+			return err
+		}
+		return fiber.NewError(fiber.StatusConflict, "mailbox owner changed")
+	}
+
+	// Retrieve the receiving account
+	account, err := app.Accounts.Account(transfer.To)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return fiber.NewError(fiber.StatusNotFound, "account not found")
+	}
+
+	if err := app.transferMailbox(claims, mailbox, account); err != nil {
+		return err
+	}
+	return ctx.JSON(mailbox)
+}
+
+// EndpointDeclineMailboxTransfer handles the 'POST /v1/mailboxes/:address/transfer/decline' API endpoint
+// The current owner of the mailbox may use it to cancel the transfer
+func (app *App) EndpointDeclineMailboxTransfer(ctx *fiber.Ctx) error {
+	transfer, err := app.retrieveMailboxTransfer(ctx, true)
+	if err != nil {
+		return err
+	}
+	return app.Transfers.Delete(transfer.Mailbox)
+}
+
+// EndpointGetAccountMailboxTransfers handles the 'GET /v1/accounts/:identifier/mailbox_transfers' API endpoint
+func (app *App) EndpointGetAccountMailboxTransfers(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	transfers, err := app.Transfers.TransfersTo(account.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(transfers)
+}
+
+// retrieveMailboxTransfer retrieves the pending transfer of the injected mailbox and checks if the executor may handle it
+// The receiving account and admins may always handle the transfer while the owner of the mailbox only may if ownerAllowed is true
+func (app *App) retrieveMailboxTransfer(ctx *fiber.Ctx, ownerAllowed bool) (*shared.MailboxTransfer, error) {
+	mailbox := ctx.Locals("_mailbox").(*shared.Mailbox)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	transfer, err := app.Transfers.Transfer(mailbox.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle authorization
+	allowed := claims.Admin || (transfer != nil && transfer.To == claims.ID) || (ownerAllowed && mailbox.Account == claims.ID)
+	if !allowed {
+		return nil, fiber.ErrForbidden
+	}
+
+	if transfer == nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "transfer not found")
+	}
+	return transfer, nil
+}
+
+// transferMailbox moves the given mailbox including all of its messages into the given account
+// The receiving account has to be allowed to create the mailbox itself
+func (app *App) transferMailbox(claims *accessTokenClaims, mailbox *shared.Mailbox, account *shared.Account) error {
+	// Check if the receiving account has exceeded its mailbox limit
+	if err := app.checkAccountMailboxLimit(account); err != nil {
+		return err
+	}
+
+	// Check if the receiving account may use the domain of the mailbox
+	at := strings.LastIndex(mailbox.Address, "@")
+	domain, err := app.checkMailboxDomain(claims, account, mailbox.Address[at+1:])
+	if err != nil {
+		return err
+	}
+
+	// Only admins and domain owners may own patterns catching large parts of the mails sent to a domain
+	if mailbox.Pattern && validation.IsPrivilegedPattern(mailbox.Address[:at]) && !claims.Admin && !isDomainOwner(domain, account.ID) {
+		return fiber.ErrForbidden
+	}
+
+	// Apply the maximum mailbox lifetime of the receiving account
+	maximum, err := resolveMailboxExpiry(nil, claims.Admin || account.Admin)
+	if err != nil {
+		return err
+	}
+	if maximum != nil && (mailbox.Expires == nil || *mailbox.Expires > *maximum) {
+		mailbox.Expires = maximum
+	}
+
+	// Change the owner of the mailbox; its messages stay attached as they reference its address
+	mailbox.Account = account.ID
+	return app.Transfers.Execute(mailbox)
+}
@@ -1,13 +1,41 @@
 package v1
 
 import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
 	"github.com/bwmarrin/snowflake"
 	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/events"
+	"github.com/poopmail/canalization/internal/hashing"
 	"github.com/poopmail/canalization/internal/shared"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// attachmentURLLifetime represents the time signed inline attachment URLs stay valid for
+	attachmentURLLifetime = time.Hour
+
+	// maxPatchMessages represents the maximum amount of messages which may be updated using a single request
+	maxPatchMessages = 500
 )
 
+// inlineContentTypes holds all attachment content types which are safe to be displayed by the client directly
+var inlineContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
 // MiddlewareInjectMessage handles message injection
-func (app *App) MiddlewareInjectMessage(handleAuth bool) fiber.Handler {
+// The executor needs at least the given level of access to the mailbox of the message
+func (app *App) MiddlewareInjectMessage(required mailboxAccess) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		rawID := ctx.Params("id")
 		id, err := snowflake.ParseString(rawID)
@@ -33,13 +61,13 @@ func (app *App) MiddlewareInjectMessage(handleAuth bool) fiber.Handler {
 			return fiber.NewError(fiber.StatusInternalServerError, "mailbox mapped but not present")
 		}
 
-		// Handle authorization if needed
-		claims := ctx.Locals("_claims").(*accessTokenClaims)
-		if handleAuth && mailbox.Account != claims.ID && !claims.Admin {
-			return fiber.ErrForbidden
+		// Handle authorization
+		if err := app.checkMailboxAccess(ctx.Locals("_claims").(*accessTokenClaims), mailbox, required); err != nil {
+			return err
 		}
 
 		ctx.Locals("_message", message)
+		ctx.Locals("_mailbox", mailbox)
 		return ctx.Next()
 	}
 }
@@ -74,30 +102,378 @@ func (app *App) EndpointGetMessages(ctx *fiber.Ctx) error {
 	}
 
 	// Handle authentication
-	if mailbox.Account != claims.ID && !claims.Admin {
-		return fiber.ErrForbidden
+	if err := app.checkMailboxAccess(claims, mailbox, mailboxAccessViewer); err != nil {
+		return err
+	}
+
+	// Build the message filter using the given header and flag query parameters
+	filter := &shared.MessageFilter{
+		Mailbox:   mailbox.Address,
+		From:      ctx.Query("from"),
+		To:        ctx.Query("to"),
+		MessageID: ctx.Query("message_id"),
+		InReplyTo: ctx.Query("in_reply_to"),
+		Tag:       ctx.Query("tag"),
+	}
+	if filter.Seen, err = parseQueryBool("seen", ctx); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+	if filter.Flagged, err = parseQueryBool("flagged", ctx); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+	if filter.Archived, err = parseQueryBool("archived", ctx); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
 	}
 
 	// Retrieve the desired amount of messages
-	count, err := app.Messages.Count(mailbox.Address)
+	count, err := app.Messages.Count(filter)
 	if err != nil {
 		return err
 	}
-	messages, err := app.Messages.Messages(mailbox.Address, skip, limit)
+	messages, err := app.Messages.Messages(filter, skip, limit)
 	if err != nil {
 		return err
 	}
+	if err := app.injectAttachments(messages...); err != nil {
+		return err
+	}
 
 	return ctx.JSON(newPaginatedResponse(messages, count, len(messages)))
 }
 
+// EndpointSearchMessages handles the 'GET /v1/messages/search' API endpoint
+func (app *App) EndpointSearchMessages(ctx *fiber.Ctx) error {
+	// Parse the 'skip' query parameter
+	skip, err := parseQueryInt("skip", 0, ctx)
+	if err != nil || skip < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	// Parse the 'limit' query parameter
+	limit, err := parseQueryInt("limit", 10, ctx)
+	if err != nil || limit < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	query := strings.TrimSpace(ctx.Query("q"))
+	if query == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Restrict the search to the mailboxes the executor owns or is a member of if they are not an admin
+	search := &shared.MessageSearch{
+		Query: query,
+	}
+	if !claims.Admin {
+		search.Account = &claims.ID
+	}
+
+	// Restrict the search to a single mailbox if the 'mailbox' query parameter is set
+	if mailboxAddress := ctx.Query("mailbox"); mailboxAddress != "" {
+		mailbox, err := app.Mailboxes.Mailbox(mailboxAddress)
+		if err != nil {
+			return err
+		}
+		if mailbox == nil {
+			return fiber.NewError(fiber.StatusNotFound, "mailbox not found")
+		}
+		if err := app.checkMailboxAccess(claims, mailbox, mailboxAccessViewer); err != nil {
+			return err
+		}
+		search.Mailbox = mailbox.Address
+	}
+
+	// Retrieve the desired amount of search results
+	count, err := app.Messages.CountSearch(search)
+	if err != nil {
+		return err
+	}
+	results, err := app.Messages.Search(search, skip, limit)
+	if err != nil {
+		return err
+	}
+	messages := make([]*shared.Message, 0, len(results))
+	for _, result := range results {
+		messages = append(messages, result.Message)
+	}
+	if err := app.injectAttachments(messages...); err != nil {
+		return err
+	}
+
+	return ctx.JSON(newPaginatedResponse(results, count, len(results)))
+}
+
 // EndpointGetMessage handles the 'GET /v1/messages/:id' API endpoint
 func (app *App) EndpointGetMessage(ctx *fiber.Ctx) error {
-	return ctx.JSON(ctx.Locals("_message").(*shared.Message))
+	message := ctx.Locals("_message").(*shared.Message)
+	if err := app.injectAttachments(message); err != nil {
+		return err
+	}
+	return ctx.JSON(message)
+}
+
+// EndpointPatchMessage handles the 'PATCH /v1/messages/:id' API endpoint
+func (app *App) EndpointPatchMessage(ctx *fiber.Ctx) error {
+	message := ctx.Locals("_message").(*shared.Message)
+
+	// Try to parse the request into a request body struct
+	body := new(shared.MessageFlags)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	// Viewers may only mark messages as seen
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+	if err := app.checkMailboxAccess(claims, ctx.Locals("_mailbox").(*shared.Mailbox), messageFlagsAccess(body)); err != nil {
+		return err
+	}
+
+	// Update the flags of the message
+	if err := app.Messages.UpdateFlags([]snowflake.ID{message.ID}, body); err != nil {
+		return err
+	}
+	if body.Seen != nil {
+		message.Seen = *body.Seen
+	}
+	if body.Flagged != nil {
+		message.Flagged = *body.Flagged
+	}
+	if body.Archived != nil {
+		message.Archived = *body.Archived
+	}
+
+	if err := app.injectAttachments(message); err != nil {
+		return err
+	}
+	return ctx.JSON(message)
+}
+
+// messageFlagsAccess returns the mailbox access required to apply the given flag update
+// Marking messages as seen only requires viewer access as it does not alter the mailbox for other members
+func messageFlagsAccess(flags *shared.MessageFlags) mailboxAccess {
+	if flags.Flagged != nil || flags.Archived != nil {
+		return mailboxAccessManager
+	}
+	return mailboxAccessViewer
+}
+
+type endpointPatchMessagesRequestBody struct {
+	shared.MessageFlags
+	IDs []snowflake.ID `json:"ids"`
+}
+
+// EndpointPatchMessages handles the 'PATCH /v1/messages' API endpoint
+func (app *App) EndpointPatchMessages(ctx *fiber.Ctx) error {
+	// Try to parse the request into a request body struct
+	body := new(endpointPatchMessagesRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+	if len(body.IDs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad request body")
+	}
+	if len(body.IDs) > maxPatchMessages {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d messages may be updated at once", maxPatchMessages))
+	}
+
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Retrieve the mailboxes of all requested messages
+	messageMailboxes, err := app.Messages.MessageMailboxes(body.IDs)
+	if err != nil {
+		return err
+	}
+
+	// Check if every requested message exists and the executor is allowed to modify it
+	required := messageFlagsAccess(&body.MessageFlags)
+	mailboxes := make(map[string]*shared.Mailbox)
+	for _, id := range body.IDs {
+		address, ok := messageMailboxes[id]
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, "message not found")
+		}
+
+		if _, ok := mailboxes[address]; ok {
+			continue
+		}
+		mailbox, err := app.Mailboxes.Mailbox(address)
+		if err != nil {
+			return err
+		}
+		if mailbox == nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "mailbox mapped but not present")
+		}
+		if err := app.checkMailboxAccess(claims, mailbox, required); err != nil {
+			return err
+		}
+		mailboxes[address] = mailbox
+	}
+
+	// Update the flags of all messages
+	if err := app.Messages.UpdateFlags(body.IDs, &body.MessageFlags); err != nil {
+		return err
+	}
+	return ctx.SendStatus(fiber.StatusOK)
 }
 
 // EndpointDeleteMessage handles the 'DELETE /v1/messages/:id' API endpoint
 func (app *App) EndpointDeleteMessage(ctx *fiber.Ctx) error {
 	message := ctx.Locals("_message").(*shared.Message)
-	return app.Messages.Delete(message.ID)
+	if err := app.Messages.Delete(message.ID); err != nil {
+		return err
+	}
+
+	// Notify all subscribers of the mailbox about the deletion
+	if err := app.Events.Publish(events.NewMessageEvent(events.TypeMessageDeleted, message)); err != nil {
+		logrus.WithError(err).Error("error while publishing message event")
+	}
+	return nil
+}
+
+// EndpointGetMessageAttachment handles the 'GET /v1/messages/:id/attachments/:attachment' API endpoint
+func (app *App) EndpointGetMessageAttachment(ctx *fiber.Ctx) error {
+	message := ctx.Locals("_message").(*shared.Message)
+
+	// Parse the snowflake ID of the attachment
+	id, err := snowflake.ParseString(ctx.Params("attachment"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+	}
+
+	// Retrieve the requested attachment
+	attachment, err := app.Attachments.Attachment(message.ID, id)
+	if err != nil {
+		return err
+	}
+	if attachment == nil {
+		return fiber.NewError(fiber.StatusNotFound, "attachment not found")
+	}
+
+	// Only images known to be harmless may be displayed by the client directly; everything else is forced to be
+	// downloaded as the content type is controlled by the sender and would otherwise allow stored XSS
+	contentType, _, err := mime.ParseMediaType(attachment.ContentType)
+	if err != nil {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if inlineContentTypes[contentType] {
+		disposition = "inline"
+	}
+
+	filename := attachment.Filename
+	if filename == "" {
+		filename = attachment.ID.String()
+	}
+
+	ctx.Set(fiber.HeaderContentType, contentType)
+	ctx.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	ctx.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	ctx.Set(fiber.HeaderContentSecurityPolicy, "sandbox")
+	return ctx.SendStream(bytes.NewReader(attachment.Data), len(attachment.Data))
+}
+
+// MiddlewareVerifyAttachmentSignature authorizes attachment requests using a signed URL instead of an access token
+// This is needed for inline attachments referenced inside the HTML content of a message as browsers are unable to
+// send the authorization header when loading images
+func (app *App) MiddlewareVerifyAttachmentSignature(ctx *fiber.Ctx) error {
+	// Validate the signature and its expiry
+	expires, err := parseQueryInt("expires", 0, ctx)
+	if err != nil || int64(expires) < time.Now().Unix() {
+		return fiber.ErrUnauthorized
+	}
+	if !hashing.CheckToken(config.Loaded.TokenHashingKey, attachmentSignaturePayload(ctx.Params("id"), ctx.Params("attachment"), int64(expires)), ctx.Query("signature")) {
+		return fiber.ErrUnauthorized
+	}
+
+	// Retrieve the message the attachment belongs to
+	id, err := snowflake.ParseString(ctx.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+	}
+	message, err := app.Messages.Message(id)
+	if err != nil {
+		return err
+	}
+	if message == nil {
+		return fiber.NewError(fiber.StatusNotFound, "message not found")
+	}
+
+	ctx.Locals("_message", message)
+	return ctx.Next()
+}
+
+// signInlineAttachments replaces all references to inline attachments inside the HTML content of the given message
+// with signed URLs
+func signInlineAttachments(message *shared.Message) {
+	if message.Content == nil || message.Content.HTML == "" {
+		return
+	}
+
+	expires := time.Now().Add(attachmentURLLifetime).Unix()
+	var replacements []string
+	for _, attachment := range message.Attachments {
+		if attachment.ContentID == "" {
+			continue
+		}
+
+		endpoint := "/v1/messages/" + message.ID.String() + "/attachments/" + attachment.ID.String()
+		signed := fmt.Sprintf("%s/signed?expires=%d&signature=%s", endpoint, expires,
+			hashing.HashToken(config.Loaded.TokenHashingKey, attachmentSignaturePayload(message.ID.String(), attachment.ID.String(), expires)))
+
+		// Messages received before inline attachments got signed reference the plain endpoint instead of the content ID
+		replacements = append(replacements, "cid:"+attachment.ContentID, signed, endpoint, signed)
+	}
+	if len(replacements) > 0 {
+		message.Content.HTML = strings.NewReplacer(replacements...).Replace(message.Content.HTML)
+	}
+}
+
+// attachmentSignaturePayload builds the payload which gets signed to authorize a specific attachment URL
+func attachmentSignaturePayload(message, attachment string, expires int64) string {
+	return fmt.Sprintf("attachment:%s:%s:%d", message, attachment, expires)
+}
+
+// injectAttachments loads the attachment metadata of all given messages and signs their inline attachment references
+func (app *App) injectAttachments(messages ...*shared.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]snowflake.ID, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	attachments, err := app.Attachments.AttachmentsOfMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		message.Attachments = attachments[message.ID]
+		if message.Attachments == nil {
+			message.Attachments = []*shared.Attachment{}
+		}
+		signInlineAttachments(message)
+	}
+	return nil
+}
+
+// EndpointGetMessageRaw handles the 'GET /v1/messages/:id/raw' API endpoint
+func (app *App) EndpointGetMessageRaw(ctx *fiber.Ctx) error {
+	message := ctx.Locals("_message").(*shared.Message)
+
+	// Retrieve the raw source of the message
+	raw, err := app.Messages.Raw(message.ID)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		return fiber.NewError(fiber.StatusNotFound, "raw message not found")
+	}
+
+	ctx.Set(fiber.HeaderContentType, "message/rfc822")
+	ctx.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": message.ID.String() + ".eml"}))
+	return ctx.SendStream(bytes.NewReader(raw), len(raw))
 }
@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+func TestMessageFlagsAccess(t *testing.T) {
+	set := true
+	tests := []struct {
+		flags    shared.MessageFlags
+		expected mailboxAccess
+	}{
+		{shared.MessageFlags{}, mailboxAccessViewer},
+		{shared.MessageFlags{Seen: &set}, mailboxAccessViewer},
+		{shared.MessageFlags{Flagged: &set}, mailboxAccessManager},
+		{shared.MessageFlags{Archived: &set}, mailboxAccessManager},
+		{shared.MessageFlags{Seen: &set, Flagged: &set}, mailboxAccessManager},
+	}
+
+	for _, test := range tests {
+		if actual := messageFlagsAccess(&test.flags); actual != test.expected {
+			t.Errorf("messageFlagsAccess(%+v): expected %d, got %d", test.flags, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package v1
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/metrics"
+)
+
+// EndpointGetMetrics handles the 'GET /v1/admin/metrics' API endpoint
+func (app *App) EndpointGetMetrics(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return ctx.SendString(metrics.JSON())
+}
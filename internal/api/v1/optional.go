@@ -0,0 +1,25 @@
+package v1
+
+import "encoding/json"
+
+// optionalInt64 represents a nullable JSON integer field which distinguishes between being absent and being null
+type optionalInt64 struct {
+	Set   bool
+	Value *int64
+}
+
+// UnmarshalJSON unmarshals the given JSON value and marks the field as set
+func (optional *optionalInt64) UnmarshalJSON(data []byte) error {
+	optional.Set = true
+	if string(data) == "null" {
+		optional.Value = nil
+		return nil
+	}
+
+	value := new(int64)
+	if err := json.Unmarshal(data, value); err != nil {
+		return err
+	}
+	optional.Value = value
+	return nil
+}
@@ -0,0 +1,301 @@
+package v1
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/hashing"
+	"github.com/poopmail/canalization/internal/id"
+	"github.com/poopmail/canalization/internal/random"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+const (
+	// personalAccessTokenPrefix represents the prefix every personal access token starts with
+	// It is used to tell personal access tokens apart from JWT access tokens and makes leaked tokens easy to detect
+	personalAccessTokenPrefix = "cpat_"
+
+	// personalAccessTokenSecretLength represents the amount of random bytes the secret of a personal access token consists of
+	personalAccessTokenSecretLength = 32
+
+	// personalAccessTokenLastUsedPrecision represents the interval in which the last usage of a personal access token gets written to the database
+	personalAccessTokenLastUsedPrecision = time.Minute
+)
+
+// Scopes which may be granted to personal access tokens
+// A write scope always includes its corresponding read scope
+const (
+	scopeAccountRead    = "account:read"
+	scopeAccountWrite   = "account:write"
+	scopeDomainsRead    = "domains:read"
+	scopeDomainsWrite   = "domains:write"
+	scopeMailboxesRead  = "mailboxes:read"
+	scopeMailboxesWrite = "mailboxes:write"
+	scopeMessagesRead   = "messages:read"
+	scopeMessagesWrite  = "messages:write"
+	scopeAdmin          = "admin"
+)
+
+var scopes = []string{
+	scopeAccountRead, scopeAccountWrite,
+	scopeDomainsRead, scopeDomainsWrite,
+	scopeMailboxesRead, scopeMailboxesWrite,
+	scopeMessagesRead, scopeMessagesWrite,
+	scopeAdmin,
+}
+
+// isValidScope checks whether the given scope is known
+func isValidScope(scope string) bool {
+	for _, known := range scopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScope checks whether the given scopes contain the given scope or the write scope including it
+func hasScope(granted []string, scope string) bool {
+	for _, candidate := range granted {
+		if candidate == scope {
+			return true
+		}
+		if strings.HasSuffix(scope, ":read") && candidate == strings.TrimSuffix(scope, ":read")+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePersonalAccessTokenScopes normalizes and validates the scopes requested for a new personal access token of the given account
+// A token may never grant more than the token used to create it
+func resolvePersonalAccessTokenScopes(claims *accessTokenClaims, account *shared.Account, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "no scopes given")
+	}
+
+	resolved := make([]string, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	for _, scope := range requested {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !isValidScope(scope) || (scope == scopeAdmin && !account.Admin) {
+			return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid scope "+scope)
+		}
+		if !claims.hasScope(scope) {
+			return nil, fiber.NewError(fiber.StatusForbidden, "missing scope "+scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			resolved = append(resolved, scope)
+		}
+	}
+	return resolved, nil
+}
+
+// MiddlewareRequireScope requires the access token to grant the given scope
+func (app *App) MiddlewareRequireScope(scope string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !ctx.Locals("_claims").(*accessTokenClaims).hasScope(scope) {
+			return fiber.NewError(fiber.StatusForbidden, "missing scope "+scope)
+		}
+		return ctx.Next()
+	}
+}
+
+// processPersonalAccessToken validates the given personal access token and builds the claims it grants
+func (app *App) processPersonalAccessToken(rawToken string) (bool, *accessTokenClaims, error) {
+	// Split the token into its ID and secret
+	split := strings.SplitN(strings.TrimPrefix(rawToken, personalAccessTokenPrefix), "_", 2)
+	if len(split) != 2 {
+		return false, nil, nil
+	}
+	tokenID, err := snowflake.ParseString(split[0])
+	if err != nil {
+		return false, nil, nil
+	}
+
+	// Retrieve and validate the personal access token
+	token, err := app.Tokens.PersonalAccessToken(tokenID)
+	if err != nil {
+		return false, nil, err
+	}
+	if token == nil || !hashing.CheckToken(config.Loaded.TokenHashingKey, split[1], token.Token) {
+		return false, nil, nil
+	}
+	now := time.Now()
+	if token.Expires != nil && *token.Expires <= now.Unix() {
+		return false, nil, nil
+	}
+
+	// Retrieve the account the token belongs to
+	account, err := app.Accounts.Account(token.Account)
+	if err != nil {
+		return false, nil, err
+	}
+	if account == nil {
+		return false, nil, nil
+	}
+
+	// Keep track of the last usage of the token without writing to the database on every single request
+	if token.LastUsed == nil || *token.LastUsed <= now.Add(-personalAccessTokenLastUsedPrecision).Unix() {
+		if err := app.Tokens.UpdateLastUsed(token.ID, now.Unix()); err != nil {
+			return false, nil, err
+		}
+	}
+
+	return true, &accessTokenClaims{
+		ID:     account.ID,
+		Admin:  account.Admin && hasScope(token.Scopes, scopeAdmin),
+		Scopes: token.Scopes,
+	}, nil
+}
+
+// personalAccessTokenCreatedResponse represents a freshly created personal access token including its plain token
+// This is the only time the plain token is revealed
+type personalAccessTokenCreatedResponse struct {
+	*shared.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// MiddlewareInjectPersonalAccessToken handles personal access token injection
+func (app *App) MiddlewareInjectPersonalAccessToken(ctx *fiber.Ctx) error {
+	// Parse the snowflake ID of the personal access token
+	id, err := snowflake.ParseString(ctx.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+	}
+
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Retrieve the personal access token
+	token, err := app.Tokens.PersonalAccessToken(id)
+	if err != nil {
+		return err
+	}
+	if token == nil || token.Account != account.ID {
+		return fiber.NewError(fiber.StatusNotFound, "personal access token not found")
+	}
+
+	ctx.Locals("_personal_access_token", token)
+	return ctx.Next()
+}
+
+// EndpointGetAccountPersonalAccessTokens handles the 'GET /v1/accounts/:identifier/tokens' API endpoint
+func (app *App) EndpointGetAccountPersonalAccessTokens(ctx *fiber.Ctx) error {
+	// Parse the 'skip' query parameter
+	skip, err := parseQueryInt("skip", 0, ctx)
+	if err != nil || skip < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	// Parse the 'limit' query parameter
+	limit, err := parseQueryInt("limit", 10, ctx)
+	if err != nil || limit < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "bad query parameter")
+	}
+
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Count the total amount of personal access tokens
+	count, err := app.Tokens.Count(account.ID)
+	if err != nil {
+		return err
+	}
+
+	// Retrieve the desired amount of personal access tokens
+	tokens, err := app.Tokens.PersonalAccessTokens(account.ID, skip, limit)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newPaginatedResponse(tokens, count, len(tokens)))
+}
+
+// EndpointGetAccountPersonalAccessToken handles the 'GET /v1/accounts/:identifier/tokens/:id' API endpoint
+func (app *App) EndpointGetAccountPersonalAccessToken(ctx *fiber.Ctx) error {
+	return ctx.JSON(ctx.Locals("_personal_access_token").(*shared.PersonalAccessToken))
+}
+
+type endpointCreateAccountPersonalAccessTokenRequestBody struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Expires *int64   `json:"expires"`
+}
+
+// EndpointCreateAccountPersonalAccessToken handles the 'POST /v1/accounts/:identifier/tokens' API endpoint
+func (app *App) EndpointCreateAccountPersonalAccessToken(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Try to parse the request into a request body struct
+	body := new(endpointCreateAccountPersonalAccessTokenRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	// Validate the name and expiry
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid name")
+	}
+	if body.Expires != nil && *body.Expires <= time.Now().Unix() {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid expiry")
+	}
+
+	// Validate the requested scopes
+	tokenScopes, err := resolvePersonalAccessTokenScopes(ctx.Locals("_claims").(*accessTokenClaims), account, body.Scopes)
+	if err != nil {
+		return err
+	}
+
+	// Generate and create the personal access token
+	secret := random.SecureHex(personalAccessTokenSecretLength)
+	token := &shared.PersonalAccessToken{
+		ID:      id.Generate(),
+		Account: account.ID,
+		Name:    name,
+		Token:   hashing.HashToken(config.Loaded.TokenHashingKey, secret),
+		Scopes:  tokenScopes,
+		Expires: body.Expires,
+		Created: time.Now().Unix(),
+	}
+	if err := app.Tokens.CreateOrReplace(token); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(personalAccessTokenCreatedResponse{
+		PersonalAccessToken: token,
+		Token:               personalAccessTokenPrefix + token.ID.String() + "_" + secret,
+	})
+}
+
+// EndpointDeleteAccountPersonalAccessToken handles the 'DELETE /v1/accounts/:identifier/tokens/:id' API endpoint
+func (app *App) EndpointDeleteAccountPersonalAccessToken(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	rawID := ctx.Params("id")
+	if strings.ToLower(rawID) != "@all" {
+		// Parse the snowflake ID of the personal access token
+		id, err := snowflake.ParseString(rawID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid snowflake ID")
+		}
+
+		// Check if the personal access token exists
+		found, err := app.Tokens.PersonalAccessToken(id)
+		if err != nil {
+			return err
+		}
+		if found == nil || found.Account != account.ID {
+			return fiber.NewError(fiber.StatusNotFound, "personal access token not found")
+		}
+
+		// Delete the personal access token
+		return app.Tokens.Delete(id)
+	}
+
+	// Delete all personal access tokens
+	return app.Tokens.DeleteAll(account.ID)
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted  []string
+		scope    string
+		expected bool
+	}{
+		{[]string{scopeMessagesRead}, scopeMessagesRead, true},
+		{[]string{scopeMessagesWrite}, scopeMessagesWrite, true},
+		{[]string{scopeMessagesWrite}, scopeMessagesRead, true},
+		{[]string{scopeMessagesRead}, scopeMessagesWrite, false},
+		{[]string{scopeMailboxesWrite}, scopeMessagesRead, false},
+		{[]string{scopeAdmin}, scopeMessagesRead, false},
+		{[]string{}, scopeMessagesRead, false},
+	}
+
+	for _, test := range tests {
+		if actual := hasScope(test.granted, test.scope); actual != test.expected {
+			t.Errorf("hasScope(%v, %s): expected %t, got %t", test.granted, test.scope, test.expected, actual)
+		}
+	}
+}
+
+func TestClaimsHasScope(t *testing.T) {
+	if !(&accessTokenClaims{}).hasScope(scopeAdmin) {
+		t.Error("expected claims without scopes to grant every scope")
+	}
+	if (&accessTokenClaims{Scopes: []string{}}).hasScope(scopeMessagesRead) {
+		t.Error("expected claims with an empty scope list to grant nothing")
+	}
+}
+
+func TestResolvePersonalAccessTokenScopes(t *testing.T) {
+	account := &shared.Account{}
+	admin := &shared.Account{Admin: true}
+	session := &accessTokenClaims{}
+	limited := &accessTokenClaims{Scopes: []string{scopeMessagesWrite}}
+
+	tests := []struct {
+		name      string
+		claims    *accessTokenClaims
+		account   *shared.Account
+		requested []string
+		expected  []string
+		status    int
+	}{
+		{"normalized and deduplicated", session, account, []string{" Messages:Read ", "messages:read", "mailboxes:write"}, []string{scopeMessagesRead, scopeMailboxesWrite}, 0},
+		{"implied by write scope", limited, account, []string{scopeMessagesRead, scopeMessagesWrite}, []string{scopeMessagesRead, scopeMessagesWrite}, 0},
+		{"exceeding the creating token", limited, account, []string{scopeMailboxesRead}, nil, fiber.StatusForbidden},
+		{"write exceeding read", &accessTokenClaims{Scopes: []string{scopeMessagesRead}}, account, []string{scopeMessagesWrite}, nil, fiber.StatusForbidden},
+		{"admin for admins", session, admin, []string{scopeAdmin}, []string{scopeAdmin}, 0},
+		{"admin for non-admins", session, account, []string{scopeAdmin}, nil, fiber.StatusUnprocessableEntity},
+		{"unknown scope", session, account, []string{"messages:delete"}, nil, fiber.StatusUnprocessableEntity},
+		{"no scopes", session, account, nil, nil, fiber.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		resolved, err := resolvePersonalAccessTokenScopes(test.claims, test.account, test.requested)
+		if test.status != 0 {
+			var fiberErr *fiber.Error
+			if !errors.As(err, &fiberErr) || fiberErr.Code != test.status {
+				t.Errorf("%s: expected status %d, got %v", test.name, test.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(resolved, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, resolved)
+		}
+	}
+}
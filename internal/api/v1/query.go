@@ -2,6 +2,7 @@ package v1
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -18,3 +19,29 @@ func parseQueryInt(key string, fallback int, ctx *fiber.Ctx) (int, error) {
 	}
 	return parsed, nil
 }
+
+func parseQueryBool(key string, ctx *fiber.Ctx) (*bool, error) {
+	value := ctx.Query(key, "")
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func parseQueryDuration(key string, fallback time.Duration, ctx *fiber.Ctx) (time.Duration, error) {
+	value := ctx.Query(key, "")
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return parsed, nil
+}
@@ -0,0 +1,447 @@
+package v1
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/hashing"
+	"github.com/poopmail/canalization/internal/id"
+	"github.com/poopmail/canalization/internal/random"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/totp"
+)
+
+const (
+	// recoveryCodeAmount represents the amount of recovery codes generated for an account at once
+	recoveryCodeAmount = 10
+
+	// recoveryCodeLength represents the amount of random bytes a recovery code consists of
+	recoveryCodeLength = 5
+
+	// twoFactorChallengeLength represents the amount of random bytes a two-factor login challenge consists of
+	twoFactorChallengeLength = 32
+
+	// twoFactorChallengeMaxAttempts represents the amount of wrong codes after which a two-factor login challenge gets discarded
+	twoFactorChallengeMaxAttempts = 5
+)
+
+// twoFactorResponse represents the two-factor authentication state of an account
+type twoFactorResponse struct {
+	Enabled       bool `json:"enabled"`
+	Pending       bool `json:"pending"`
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+// EndpointGetAccountTwoFactor handles the 'GET /v1/accounts/:identifier/two_factor' API endpoint
+func (app *App) EndpointGetAccountTwoFactor(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil {
+		return ctx.JSON(&twoFactorResponse{})
+	}
+
+	codes, err := app.TwoFactor.RecoveryCodes(account.ID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(&twoFactorResponse{
+		Enabled:       twoFactor.Confirmed,
+		Pending:       !twoFactor.Confirmed,
+		RecoveryCodes: len(codes),
+	})
+}
+
+// EndpointCreateAccountTwoFactor handles the 'POST /v1/accounts/:identifier/two_factor' API endpoint
+// It starts the enrollment by generating a new secret which has to be confirmed using a valid code afterwards
+func (app *App) EndpointCreateAccountTwoFactor(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Only the account owner may enroll
+	if ctx.Locals("_claims").(*accessTokenClaims).ID != account.ID {
+		return fiber.ErrForbidden
+	}
+
+	// Check if two-factor authentication is already enabled
+	found, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if found != nil && found.Confirmed {
+		return fiber.NewError(fiber.StatusConflict, "two-factor authentication already enabled")
+	}
+
+	// Create the pending two-factor authentication configuration
+	twoFactor := &shared.TwoFactor{
+		Account:   account.ID,
+		Secret:    totp.GenerateSecret(),
+		Confirmed: false,
+		Created:   time.Now().Unix(),
+	}
+	if err := app.TwoFactor.CreateOrReplace(twoFactor); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"secret": twoFactor.Secret,
+		"uri":    totp.URI(config.Loaded.TwoFactorIssuer, account.Username, twoFactor.Secret),
+	})
+}
+
+type endpointConfirmAccountTwoFactorRequestBody struct {
+	Code string `json:"code"`
+}
+
+// EndpointConfirmAccountTwoFactor handles the 'POST /v1/accounts/:identifier/two_factor/confirm' API endpoint
+func (app *App) EndpointConfirmAccountTwoFactor(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Only the account owner may confirm the enrollment
+	if ctx.Locals("_claims").(*accessTokenClaims).ID != account.ID {
+		return fiber.ErrForbidden
+	}
+
+	// Try to parse the request into a request body struct
+	body := new(endpointConfirmAccountTwoFactorRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	// Retrieve the pending two-factor authentication configuration
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil {
+		return fiber.NewError(fiber.StatusNotFound, "two-factor authentication not set up")
+	}
+	if twoFactor.Confirmed {
+		return fiber.NewError(fiber.StatusConflict, "two-factor authentication already enabled")
+	}
+
+	// Validate the given code
+	valid, err := app.checkSecondFactor(twoFactor, body.Code, "")
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid code")
+	}
+
+	// Enable two-factor authentication and hand out the initial recovery codes
+	twoFactor.Confirmed = true
+	if err := app.TwoFactor.CreateOrReplace(twoFactor); err != nil {
+		return err
+	}
+	codes, err := app.regenerateRecoveryCodes(account.ID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+type endpointRegenerateAccountRecoveryCodesRequestBody struct {
+	Code string `json:"code"`
+}
+
+// EndpointRegenerateAccountRecoveryCodes handles the 'POST /v1/accounts/:identifier/two_factor/recovery_codes' API endpoint
+func (app *App) EndpointRegenerateAccountRecoveryCodes(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+
+	// Only the account owner may regenerate the recovery codes
+	if ctx.Locals("_claims").(*accessTokenClaims).ID != account.ID {
+		return fiber.ErrForbidden
+	}
+
+	// Try to parse the request into a request body struct
+	body := new(endpointRegenerateAccountRecoveryCodesRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+
+	// Retrieve the two-factor authentication configuration
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil || !twoFactor.Confirmed {
+		return fiber.NewError(fiber.StatusNotFound, "two-factor authentication not enabled")
+	}
+
+	// Validate the given code
+	valid, err := app.checkSecondFactor(twoFactor, body.Code, "")
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid code")
+	}
+
+	// Replace the recovery codes
+	codes, err := app.regenerateRecoveryCodes(account.ID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+type endpointDeleteAccountTwoFactorRequestBody struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// EndpointDeleteAccountTwoFactor handles the 'DELETE /v1/accounts/:identifier/two_factor' API endpoint
+// Account owners have to prove possession of a second factor; admins may reset the two-factor authentication of other
+// accounts without doing so
+func (app *App) EndpointDeleteAccountTwoFactor(ctx *fiber.Ctx) error {
+	account := ctx.Locals("_account").(*shared.Account)
+	claims := ctx.Locals("_claims").(*accessTokenClaims)
+
+	// Retrieve the two-factor authentication configuration
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil {
+		return fiber.NewError(fiber.StatusNotFound, "two-factor authentication not set up")
+	}
+
+	// Validate the given code if required
+	if twoFactor.Confirmed && (claims.ID == account.ID || !claims.Admin) {
+		// Try to parse the request into a request body struct
+		body := new(endpointDeleteAccountTwoFactorRequestBody)
+		if len(ctx.Body()) > 0 {
+			if err := ctx.BodyParser(body); err != nil {
+				return err
+			}
+		}
+
+		valid, err := app.checkSecondFactor(twoFactor, body.Code, body.RecoveryCode)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid code")
+		}
+	}
+
+	// Disable two-factor authentication
+	return app.TwoFactor.Delete(account.ID)
+}
+
+type endpointPostTwoFactorRequestBody struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// EndpointPostTwoFactor handles the 'POST /v1/auth/two_factor' API endpoint
+// It completes a login started at 'POST /v1/auth/refresh_token' for accounts with two-factor authentication enabled
+func (app *App) EndpointPostTwoFactor(ctx *fiber.Ctx) error {
+	// Try to parse the request into a request body struct
+	body := new(endpointPostTwoFactorRequestBody)
+	if err := ctx.BodyParser(body); err != nil {
+		return err
+	}
+	if body.Challenge == "" || (body.Code == "" && body.RecoveryCode == "") {
+		return fiber.ErrBadRequest
+	}
+
+	// Retrieve the challenge and the account it has been created for
+	challenge, err := app.Challenges.Challenge(body.Challenge)
+	if err != nil {
+		return err
+	}
+	if challenge == nil {
+		return fiber.ErrUnauthorized
+	}
+	account, err := app.Accounts.Account(challenge.Account)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		if err := app.Challenges.Delete(body.Challenge); err != nil {
+			return err
+		}
+		return fiber.ErrUnauthorized
+	}
+
+	// Validate the second factor if two-factor authentication has not been reset in the meantime
+	twoFactor, err := app.TwoFactor.TwoFactor(account.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactor != nil && twoFactor.Confirmed {
+		valid, err := app.checkLoginSecondFactor(twoFactor, body.Code, body.RecoveryCode)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			// Discard the challenge after too many wrong codes to prevent brute-forcing
+			attempts, err := app.Challenges.Fail(body.Challenge)
+			if err != nil {
+				return err
+			}
+			if attempts < 0 || attempts >= twoFactorChallengeMaxAttempts {
+				if err := app.Challenges.Delete(body.Challenge); err != nil {
+					return err
+				}
+			}
+			return fiber.ErrUnauthorized
+		}
+	}
+
+	// Complete the login
+	if err := app.Challenges.Delete(body.Challenge); err != nil {
+		return err
+	}
+	return app.createSession(ctx, account)
+}
+
+// createTwoFactorChallenge creates a new two-factor login challenge for the given account
+func (app *App) createTwoFactorChallenge(ctx *fiber.Ctx, account *shared.Account) error {
+	// Do not hand out new challenges to locked accounts as every challenge allows a few more guesses
+	if err := app.checkTwoFactorLockout(account.ID); err != nil {
+		return err
+	}
+
+	challenge := random.SecureHex(twoFactorChallengeLength)
+	if err := app.Challenges.Create(challenge, account.ID, config.Loaded.TwoFactorChallengeLifetime); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"two_factor_required": true,
+		"challenge":           challenge,
+		"expires":             time.Now().Add(config.Loaded.TwoFactorChallengeLifetime).Unix(),
+	})
+}
+
+// checkSecondFactor checks the given TOTP code or, if none is given, the given recovery code
+// No codes are checked at all while the account is locked out.
+func (app *App) checkSecondFactor(twoFactor *shared.TwoFactor, code, recoveryCode string) (bool, error) {
+	if err := app.checkTwoFactorLockout(twoFactor.Account); err != nil {
+		return false, err
+	}
+
+	if code != "" {
+		return app.checkTOTPCode(twoFactor, code)
+	}
+	if recoveryCode != "" {
+		return app.useRecoveryCode(twoFactor.Account, recoveryCode)
+	}
+	return false, nil
+}
+
+// checkLoginSecondFactor checks the second factor given to complete a login
+// Failed attempts are counted per account across all challenges; once too many of them occurred inside the lockout
+// window, no more codes are checked at all. Failures outside of logins, e.g. while confirming an enrollment, do not count.
+func (app *App) checkLoginSecondFactor(twoFactor *shared.TwoFactor, code, recoveryCode string) (bool, error) {
+	valid, err := app.checkSecondFactor(twoFactor, code, recoveryCode)
+	if err != nil {
+		return false, err
+	}
+
+	if !valid {
+		if _, err := app.Challenges.RecordAccountFailure(twoFactor.Account, config.Loaded.TwoFactorLockoutDuration); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, app.Challenges.ResetAccountFailures(twoFactor.Account)
+}
+
+// checkTwoFactorLockout rejects second factor attempts of accounts which failed too often recently
+func (app *App) checkTwoFactorLockout(account snowflake.ID) error {
+	failures, err := app.Challenges.AccountFailures(account)
+	if err != nil {
+		return err
+	}
+	if failures >= config.Loaded.TwoFactorMaxFailures {
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many failed two-factor attempts")
+	}
+	return nil
+}
+
+// checkTOTPCode checks the given TOTP code and marks its time step as used so that it cannot be replayed
+func (app *App) checkTOTPCode(twoFactor *shared.TwoFactor, code string) (bool, error) {
+	step, valid := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !valid {
+		return false, nil
+	}
+
+	used, err := app.TwoFactor.UseStep(twoFactor.Account, step)
+	if err != nil {
+		return false, err
+	}
+	if used {
+		twoFactor.LastStep = step
+	}
+	return used, nil
+}
+
+// useRecoveryCode checks the given recovery code against all unused ones of the given account and consumes the matching one
+// As this runs an argon2id comparison per unused code, malformed codes are rejected upfront and no codes are checked
+// while the account is locked out.
+func (app *App) useRecoveryCode(account snowflake.ID, recoveryCode string) (bool, error) {
+	normalized := normalizeRecoveryCode(recoveryCode)
+	if len(normalized) != recoveryCodeLength*2 || strings.Trim(normalized, "0123456789abcdef") != "" {
+		return false, nil
+	}
+
+	codes, err := app.TwoFactor.RecoveryCodes(account)
+	if err != nil {
+		return false, err
+	}
+
+	for _, code := range codes {
+		if valid, _ := hashing.Check(normalized, code.Code); valid {
+			return app.TwoFactor.UseRecoveryCode(code.ID)
+		}
+	}
+	return false, nil
+}
+
+// regenerateRecoveryCodes replaces all recovery codes of the given account and returns the new plain ones
+func (app *App) regenerateRecoveryCodes(account snowflake.ID) ([]string, error) {
+	plain := make([]string, 0, recoveryCodeAmount)
+	codes := make([]*shared.RecoveryCode, 0, recoveryCodeAmount)
+	for i := 0; i < recoveryCodeAmount; i++ {
+		raw := random.SecureHex(recoveryCodeLength)
+		hash, err := hashing.Hash(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		plain = append(plain, raw[:len(raw)/2]+"-"+raw[len(raw)/2:])
+		codes = append(codes, &shared.RecoveryCode{
+			ID:      id.Generate(),
+			Account: account,
+			Code:    hash,
+			Created: time.Now().Unix(),
+		})
+	}
+
+	if err := app.TwoFactor.ReplaceRecoveryCodes(account, codes); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// normalizeRecoveryCode removes all formatting out of the given recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
@@ -0,0 +1,128 @@
+package v1
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/config"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/totp"
+)
+
+// fakeTwoFactorService keeps track of the last used time step of every account like the postgres implementation does
+type fakeTwoFactorService struct {
+	shared.TwoFactorService
+	lastSteps map[snowflake.ID]int64
+}
+
+func (service *fakeTwoFactorService) UseStep(account snowflake.ID, step int64) (bool, error) {
+	if last, ok := service.lastSteps[account]; ok && last >= step {
+		return false, nil
+	}
+	service.lastSteps[account] = step
+	return true, nil
+}
+
+// fakeChallengeService keeps track of the failed second factor attempts of every account
+type fakeChallengeService struct {
+	shared.TwoFactorChallengeService
+	failures map[snowflake.ID]int
+}
+
+func (service *fakeChallengeService) AccountFailures(account snowflake.ID) (int, error) {
+	return service.failures[account], nil
+}
+
+func (service *fakeChallengeService) RecordAccountFailure(account snowflake.ID, _ time.Duration) (int, error) {
+	service.failures[account]++
+	return service.failures[account], nil
+}
+
+func (service *fakeChallengeService) ResetAccountFailures(account snowflake.ID) error {
+	delete(service.failures, account)
+	return nil
+}
+
+func newTwoFactorTestApp() (*App, *fakeChallengeService) {
+	challenges := &fakeChallengeService{failures: make(map[snowflake.ID]int)}
+	return &App{
+		TwoFactor:  &fakeTwoFactorService{lastSteps: make(map[snowflake.ID]int64)},
+		Challenges: challenges,
+	}, challenges
+}
+
+func newTestTwoFactor(t *testing.T) (*shared.TwoFactor, string) {
+	twoFactor := &shared.TwoFactor{Account: 1, Secret: totp.GenerateSecret(), Confirmed: true}
+	code, err := totp.Code(twoFactor.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return twoFactor, code
+}
+
+func TestCheckTOTPCodeRejectsReplay(t *testing.T) {
+	app, _ := newTwoFactorTestApp()
+	twoFactor, code := newTestTwoFactor(t)
+
+	valid, err := app.checkTOTPCode(twoFactor, code)
+	if err != nil || !valid {
+		t.Fatalf("expected the first use of the code to be accepted, got %t (%v)", valid, err)
+	}
+	valid, err = app.checkTOTPCode(twoFactor, code)
+	if err != nil || valid {
+		t.Fatalf("expected the replayed code to be rejected, got %t (%v)", valid, err)
+	}
+}
+
+func TestCheckSecondFactorDoesNotCountFailures(t *testing.T) {
+	app, challenges := newTwoFactorTestApp()
+	twoFactor, _ := newTestTwoFactor(t)
+
+	for i := 0; i < config.Loaded.TwoFactorMaxFailures+1; i++ {
+		if valid, err := app.checkSecondFactor(twoFactor, "wrong", ""); err != nil || valid {
+			t.Fatalf("expected a wrong code to be rejected, got %t (%v)", valid, err)
+		}
+	}
+	if failures := challenges.failures[twoFactor.Account]; failures != 0 {
+		t.Errorf("expected failures outside of logins not to be counted, got %d", failures)
+	}
+}
+
+func TestCheckLoginSecondFactorLocksOut(t *testing.T) {
+	app, challenges := newTwoFactorTestApp()
+	twoFactor, code := newTestTwoFactor(t)
+
+	for i := 0; i < config.Loaded.TwoFactorMaxFailures; i++ {
+		if valid, err := app.checkLoginSecondFactor(twoFactor, "wrong", ""); err != nil || valid {
+			t.Fatalf("expected a wrong code to be rejected, got %t (%v)", valid, err)
+		}
+	}
+	if failures := challenges.failures[twoFactor.Account]; failures != config.Loaded.TwoFactorMaxFailures {
+		t.Errorf("expected %d failures to be counted, got %d", config.Loaded.TwoFactorMaxFailures, failures)
+	}
+
+	// Even the correct code is rejected while the account is locked out
+	_, err := app.checkLoginSecondFactor(twoFactor, code, "")
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) || fiberErr.Code != fiber.StatusTooManyRequests {
+		t.Errorf("expected the locked out account to be rejected, got %v", err)
+	}
+}
+
+func TestCheckLoginSecondFactorResetsFailures(t *testing.T) {
+	app, challenges := newTwoFactorTestApp()
+	twoFactor, code := newTestTwoFactor(t)
+
+	if _, err := app.checkLoginSecondFactor(twoFactor, "wrong", ""); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := app.checkLoginSecondFactor(twoFactor, code, ""); err != nil || !valid {
+		t.Fatalf("expected the correct code to be accepted, got %t (%v)", valid, err)
+	}
+	if failures := challenges.failures[twoFactor.Account]; failures != 0 {
+		t.Errorf("expected the failures to be reset after a successful login, got %d", failures)
+	}
+}
@@ -3,7 +3,11 @@ package v1
 import (
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
+	"github.com/poopmail/canalization/internal/events"
+	"github.com/poopmail/canalization/internal/mails"
 	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/signing"
+	"github.com/poopmail/canalization/internal/verification"
 )
 
 // App represents the v1 API app
@@ -13,39 +17,95 @@ type App struct {
 	Invites       shared.InviteService
 	Mailboxes     shared.MailboxService
 	Messages      shared.MessageService
+	Attachments   shared.AttachmentService
+	DeadLetters   shared.DeadLetterService
+	Members       shared.MailboxMemberService
+	Transfers     shared.MailboxTransferService
+	Tokens        shared.PersonalAccessTokenService
+	TwoFactor     shared.TwoFactorService
+	Domains       shared.DomainService
+	DomainCache   shared.DomainCache
+	Revocations   shared.RevocationService
+	Challenges    shared.TwoFactorChallengeService
+	Keys          *signing.KeySet
+	Resolver      verification.TXTResolver
+	Mails         *mails.Processor
+	Events        *events.Broker
 	Redis         *redis.Client
 }
 
 // Route routes the v1 API endpoints
 func (app *App) Route(router fiber.Router) {
 	router.Get("/info", app.EndpointGetInfo)
-	router.Get("/domains", app.MiddlewareHandleBasicAuth, app.EndpointGetDomains)
+	router.Get("/domains", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsRead), app.EndpointGetDomains)
+	router.Get("/domains/:name", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsRead), app.MiddlewareInjectDomain, app.EndpointGetDomain)
+	router.Post("/domains", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsWrite), app.EndpointCreateDomain)
+	router.Post("/domains/:name/verify", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsWrite), app.MiddlewareInjectDomain, app.EndpointVerifyDomain)
+	router.Patch("/domains/:name", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsWrite), app.MiddlewareRequireAdminAuth, app.MiddlewareInjectDomain, app.EndpointPatchDomain)
+	router.Delete("/domains/:name", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeDomainsWrite), app.MiddlewareInjectDomain, app.EndpointDeleteDomain)
 
-	router.Get("/accounts", app.MiddlewareHandleBasicAuth, app.EndpointGetAccounts)
-	router.Get("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.EndpointGetAccount)
+	router.Get("/accounts", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.EndpointGetAccounts)
+	router.Get("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.EndpointGetAccount)
 	router.Post("/accounts", app.EndpointCreateAccount)
-	router.Patch("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.EndpointPatchAccount)
-	router.Delete("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.EndpointDeleteAccount)
-	router.Get("/accounts/:identifier/refresh_tokens", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.EndpointGetAccountRefreshTokens)
-	router.Get("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.MiddlewareInjectRefreshToken, app.EndpointGetAccountRefreshToken)
-	router.Patch("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.MiddlewareInjectRefreshToken, app.EndpointPatchAccountRefreshToken)
-	router.Delete("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectAccount(true), app.EndpointDeleteAccountRefreshToken)
-
-	router.Get("/mailboxes/check/:address", app.MiddlewareHandleBasicAuth, app.EndpointCheckMailboxAddress)
-	router.Get("/mailboxes", app.MiddlewareHandleBasicAuth, app.EndpointGetMailboxes)
-	router.Get("/mailboxes/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectMailbox(true), app.EndpointGetMailbox)
-	router.Post("/mailboxes", app.MiddlewareHandleBasicAuth, app.EndpointCreateMailbox)
-	router.Delete("/mailboxes/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectMailbox(true), app.EndpointDeleteMailbox)
-
-	router.Get("/messages", app.MiddlewareHandleBasicAuth, app.EndpointGetMessages)
-	router.Get("/messages/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectMessage(true), app.EndpointGetMessage)
-	router.Delete("/messages/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareInjectMessage(true), app.EndpointDeleteMessage)
+	router.Patch("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointPatchAccount)
+	router.Delete("/accounts/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointDeleteAccount)
+	router.Get("/accounts/:identifier/mailbox_transfers", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.MiddlewareInjectAccount(true), app.EndpointGetAccountMailboxTransfers)
+	router.Get("/accounts/:identifier/refresh_tokens", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.EndpointGetAccountRefreshTokens)
+	router.Get("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.MiddlewareInjectRefreshToken, app.EndpointGetAccountRefreshToken)
+	router.Patch("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.MiddlewareInjectRefreshToken, app.EndpointPatchAccountRefreshToken)
+	router.Delete("/accounts/:identifier/refresh_tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointDeleteAccountRefreshToken)
+	router.Get("/accounts/:identifier/two_factor", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.EndpointGetAccountTwoFactor)
+	router.Post("/accounts/:identifier/two_factor", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointCreateAccountTwoFactor)
+	router.Post("/accounts/:identifier/two_factor/confirm", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointConfirmAccountTwoFactor)
+	router.Post("/accounts/:identifier/two_factor/recovery_codes", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointRegenerateAccountRecoveryCodes)
+	router.Delete("/accounts/:identifier/two_factor", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointDeleteAccountTwoFactor)
+	router.Get("/accounts/:identifier/tokens", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.EndpointGetAccountPersonalAccessTokens)
+	router.Get("/accounts/:identifier/tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountRead), app.MiddlewareInjectAccount(true), app.MiddlewareInjectPersonalAccessToken, app.EndpointGetAccountPersonalAccessToken)
+	router.Post("/accounts/:identifier/tokens", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointCreateAccountPersonalAccessToken)
+	router.Delete("/accounts/:identifier/tokens/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeAccountWrite), app.MiddlewareInjectAccount(true), app.EndpointDeleteAccountPersonalAccessToken)
+
+	router.Get("/mailboxes/check/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.EndpointCheckMailboxAddress)
+	router.Get("/mailboxes", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.EndpointGetMailboxes)
+	router.Get("/mailboxes/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointGetMailbox)
+	router.Post("/mailboxes", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.EndpointCreateMailbox)
+	router.Post("/mailboxes/random", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.EndpointCreateRandomMailbox)
+	router.Patch("/mailboxes/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessOwner), app.EndpointPatchMailbox)
+	router.Delete("/mailboxes/:address", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessOwner), app.EndpointDeleteMailbox)
+	router.Get("/mailboxes/:address/events", app.MiddlewareAccessTokenFromQuery, app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointGetMailboxEvents)
+	router.Get("/mailboxes/:address/messages/next", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointGetNextMailboxMessage)
+	router.Get("/mailboxes/:address/members", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointGetMailboxMembers)
+	router.Post("/mailboxes/:address/members", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessManager), app.EndpointCreateMailboxMember)
+	router.Delete("/mailboxes/:address/members/:identifier", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointDeleteMailboxMember)
+	router.Post("/mailboxes/:address/transfer", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessOwner), app.EndpointCreateMailboxTransfer)
+	router.Get("/mailboxes/:address/transfer", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesRead), app.MiddlewareInjectMailbox(mailboxAccessNone), app.EndpointGetMailboxTransfer)
+	router.Post("/mailboxes/:address/transfer/accept", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessNone), app.EndpointAcceptMailboxTransfer)
+	router.Post("/mailboxes/:address/transfer/decline", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMailboxesWrite), app.MiddlewareInjectMailbox(mailboxAccessNone), app.EndpointDeclineMailboxTransfer)
+	router.Get("/mailboxes/:address/events/ws", app.MiddlewareRequireWebSocketUpgrade, app.MiddlewareAccessTokenFromQuery, app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMailbox(mailboxAccessViewer), app.EndpointGetMailboxEventsWebSocket)
+
+	router.Get("/messages", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.EndpointGetMessages)
+	router.Get("/messages/search", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.EndpointSearchMessages)
+	router.Patch("/messages", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesWrite), app.EndpointPatchMessages)
+	router.Get("/messages/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMessage(mailboxAccessViewer), app.EndpointGetMessage)
+	router.Patch("/messages/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesWrite), app.MiddlewareInjectMessage(mailboxAccessViewer), app.EndpointPatchMessage)
+	router.Delete("/messages/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesWrite), app.MiddlewareInjectMessage(mailboxAccessManager), app.EndpointDeleteMessage)
+	router.Get("/messages/:id/raw", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMessage(mailboxAccessViewer), app.EndpointGetMessageRaw)
+	router.Get("/messages/:id/attachments/:attachment", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireScope(scopeMessagesRead), app.MiddlewareInjectMessage(mailboxAccessViewer), app.EndpointGetMessageAttachment)
+	router.Get("/messages/:id/attachments/:attachment/signed", app.MiddlewareVerifyAttachmentSignature, app.EndpointGetMessageAttachment)
 
 	router.Get("/invites", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.EndpointGetInvites)
 	router.Get("/invites/:code", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.MiddlewareInjectInvite, app.EndpointGetInvite)
 	router.Post("/invites", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.EndpointCreateInvite)
 	router.Delete("/invites/:code", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.MiddlewareInjectInvite, app.EndpointDeleteInvite)
 
+	router.Get("/admin/metrics", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.EndpointGetMetrics)
+	router.Get("/admin/dead_letters", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.EndpointGetDeadLetters)
+	router.Get("/admin/dead_letters/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.MiddlewareInjectDeadLetter, app.EndpointGetDeadLetter)
+	router.Post("/admin/dead_letters/:id/retry", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.MiddlewareInjectDeadLetter, app.EndpointRetryDeadLetter)
+	router.Delete("/admin/dead_letters/:id", app.MiddlewareHandleBasicAuth, app.MiddlewareRequireAdminAuth, app.MiddlewareInjectDeadLetter, app.EndpointDeleteDeadLetter)
+
 	router.Post("/auth/refresh_token", app.EndpointPostRefreshToken)
+	router.Post("/auth/two_factor", app.EndpointPostTwoFactor)
 	router.Get("/auth/access_token", app.EndpointGetAccessToken)
+	router.Post("/auth/logout", app.MiddlewareHandleBasicAuth, app.EndpointPostLogout)
+	router.Get("/auth/jwks.json", app.EndpointGetJWKS)
 }
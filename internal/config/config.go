@@ -1,6 +1,7 @@
 package config
 
 import (
+	"os"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,16 +16,41 @@ var Loaded *Config
 type Config struct {
 	KarenRedisChannel           string
 	MailsRedisChannel           string
+	MailsRedisStream            string
+	MailsConsumerGroup          string
+	MailsConsumerName           string
+	MailsClaimIdleTime          time.Duration
+	SubaddressDelimiters        string
+	EventsRedisChannel          string
+	LongPollMaxTimeout          time.Duration
 	PostgresDSN                 string
 	RefreshTokenLifetime        time.Duration
 	RefreshTokenCleanupInterval time.Duration
+	RefreshTokenReuseGrace      time.Duration
 	AccessTokenLifetime         time.Duration
 	AccessTokenSigningKey       []byte
+	AccessTokenSigningKeyFile   string
+	AccessTokenVerificationKeys []string
+	TokenHashingKey             []byte
+	TwoFactorIssuer             string
+	TwoFactorChallengeLifetime  time.Duration
+	TwoFactorMaxFailures        int
+	TwoFactorLockoutDuration    time.Duration
 	RedisURL                    string
 	DomainOverride              []string
 	APIAddress                  string
 	APIRateLimit                int
+	APIProxyHeader              string
 	AccountMailboxLimit         int
+	AccountDomainLimit          int
+	DomainClaimLifetime         time.Duration
+	DNSResolverAddress          string
+	MailboxMaxTTL               time.Duration
+	MailboxKeyStrategy          string
+	MailboxExpiryInterval       time.Duration
+	MessageRetention            time.Duration
+	RetentionSweepInterval      time.Duration
+	RetentionSweepBatchSize     int
 }
 
 func init() {
@@ -33,15 +59,49 @@ func init() {
 	Loaded = &Config{
 		KarenRedisChannel:           env.MustString("CANAL_KAREN_REDIS_CHANNEL", "karen"),
 		MailsRedisChannel:           env.MustString("CANAL_MAILS_REDIS_CHANNEL", "mails"),
+		MailsRedisStream:            env.MustString("CANAL_MAILS_REDIS_STREAM", "mails"),
+		MailsConsumerGroup:          env.MustString("CANAL_MAILS_CONSUMER_GROUP", "canalization"),
+		MailsConsumerName:           env.MustString("CANAL_MAILS_CONSUMER_NAME", hostname()),
+		MailsClaimIdleTime:          env.MustDuration("CANAL_MAILS_CLAIM_IDLE_TIME", false, time.Minute),
+		SubaddressDelimiters:        env.MustString("CANAL_SUBADDRESS_DELIMITERS", "+"),
+		EventsRedisChannel:          env.MustString("CANAL_EVENTS_REDIS_CHANNEL", "canalization_events"),
+		LongPollMaxTimeout:          env.MustDuration("CANAL_LONG_POLL_MAX_TIMEOUT", false, 2*time.Minute),
 		PostgresDSN:                 env.MustString("CANAL_POSTGRES_DSN", ""),
 		RefreshTokenLifetime:        env.MustDuration("CANAL_REFRESH_TOKEN_LIFETIME", false, 7*24*time.Hour),
 		RefreshTokenCleanupInterval: env.MustDuration("CANAL_REFRESH_TOKEN_CLEANUP_INTERVAL", false, 60*time.Minute),
+		RefreshTokenReuseGrace:      env.MustDuration("CANAL_REFRESH_TOKEN_REUSE_GRACE", false, 10*time.Second),
 		AccessTokenLifetime:         env.MustDuration("CANAL_ACCESS_TOKEN_LIFETIME", false, 15*time.Minute),
 		AccessTokenSigningKey:       []byte(env.MustString("CANAL_ACCESS_TOKEN_SIGNING_KEY", random.RandomString(64))),
+		AccessTokenSigningKeyFile:   env.MustString("CANAL_ACCESS_TOKEN_SIGNING_KEY_FILE", ""),
+		AccessTokenVerificationKeys: env.MustStringSlice("CANAL_ACCESS_TOKEN_VERIFICATION_KEY_FILES", ",", []string{}),
+		TwoFactorIssuer:             env.MustString("CANAL_TWO_FACTOR_ISSUER", "poopmail"),
+		TwoFactorChallengeLifetime:  env.MustDuration("CANAL_TWO_FACTOR_CHALLENGE_LIFETIME", false, 5*time.Minute),
+		TwoFactorMaxFailures:        env.MustInt("CANAL_TWO_FACTOR_MAX_FAILURES", 10),
+		TwoFactorLockoutDuration:    env.MustDuration("CANAL_TWO_FACTOR_LOCKOUT_DURATION", false, 15*time.Minute),
+		TokenHashingKey:             []byte(env.MustString("CANAL_TOKEN_HASHING_KEY", "")),
 		RedisURL:                    env.MustString("CANAL_REDIS_URL", "redis://localhost:6379/0"),
 		DomainOverride:              env.MustStringSlice("CANAL_DOMAIN_OVERRIDE", ",", []string{}),
 		APIAddress:                  env.MustString("CANAL_API_ADDRESS", ":8080"),
 		APIRateLimit:                env.MustInt("CANAL_API_RATE_LIMIT", 60),
+		APIProxyHeader:              env.MustString("CANAL_API_PROXY_HEADER", ""),
 		AccountMailboxLimit:         env.MustInt("CANAL_ACCOUNT_MAILBOX_LIMIT", 10),
+		AccountDomainLimit:          env.MustInt("CANAL_ACCOUNT_DOMAIN_LIMIT", 3),
+		DomainClaimLifetime:         env.MustDuration("CANAL_DOMAIN_CLAIM_LIFETIME", false, 72*time.Hour),
+		DNSResolverAddress:          env.MustString("CANAL_DNS_RESOLVER_ADDRESS", ""),
+		MailboxMaxTTL:               env.MustDuration("CANAL_MAILBOX_MAX_TTL", false, 0),
+		MailboxKeyStrategy:          env.MustString("CANAL_MAILBOX_KEY_STRATEGY", "adjective_noun"),
+		MailboxExpiryInterval:       env.MustDuration("CANAL_MAILBOX_EXPIRY_INTERVAL", false, time.Minute),
+		MessageRetention:            env.MustDuration("CANAL_MESSAGE_RETENTION", false, 30*24*time.Hour),
+		RetentionSweepInterval:      env.MustDuration("CANAL_RETENTION_SWEEP_INTERVAL", false, 10*time.Minute),
+		RetentionSweepBatchSize:     env.MustInt("CANAL_RETENTION_SWEEP_BATCH_SIZE", 1000),
 	}
 }
+
+// hostname returns the host name of the machine or a random string if it cannot be determined
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return random.RandomString(16)
+	}
+	return name
+}
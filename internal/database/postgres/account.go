@@ -87,16 +87,17 @@ func (service *accountService) AccountByUsername(username string) (*shared.Accou
 // CreateOrReplace creates or replaces an account inside the database
 func (service *accountService) CreateOrReplace(account *shared.Account) error {
 	query := `
-		INSERT INTO accounts (id, username, password, admin, created)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO accounts (id, username, password, admin, created, retention)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (id) DO UPDATE
 			SET username = excluded.username,
 				password = excluded.password,
 				admin = excluded.admin,
-				created = excluded.created
+				created = excluded.created,
+				retention = excluded.retention
 	`
 
-	_, err := service.pool.Exec(context.Background(), query, account.ID, account.Username, account.Password, account.Admin, account.Created)
+	_, err := service.pool.Exec(context.Background(), query, account.ID, account.Username, account.Password, account.Admin, account.Created, account.Retention)
 	return err
 }
 
@@ -111,7 +112,7 @@ func (service *accountService) Delete(id snowflake.ID) error {
 func rowToAccount(row pgx.Row) (*shared.Account, error) {
 	account := new(shared.Account)
 
-	if err := row.Scan(&account.ID, &account.Username, &account.Password, &account.Admin, &account.Created); err != nil {
+	if err := row.Scan(&account.ID, &account.Username, &account.Password, &account.Admin, &account.Created, &account.Retention); err != nil {
 		return nil, err
 	}
 
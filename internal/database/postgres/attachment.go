@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// attachmentService represents the postgres attachment service implementation
+type attachmentService struct {
+	pool *pgxpool.Pool
+}
+
+// AttachmentsOfMessages retrieves the metadata of all attachments of the given messages out of the database
+// The attachments are returned by the IDs of their messages; the data of the attachments is not loaded
+func (service *attachmentService) AttachmentsOfMessages(messages []snowflake.ID) (map[snowflake.ID][]*shared.Attachment, error) {
+	query := `SELECT id, message, filename, content_type, size, content_id, ''::bytea, created FROM attachments WHERE message = ANY($1) ORDER BY id`
+
+	rawIDs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		rawIDs = append(rawIDs, message.Int64())
+	}
+
+	rows, err := service.pool.Query(context.Background(), query, rawIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[snowflake.ID][]*shared.Attachment, len(messages))
+	for rows.Next() {
+		attachment, err := rowToAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[attachment.Message] = append(attachments[attachment.Message], attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+// Attachment retrieves a specific attachment including its data out of the database
+func (service *attachmentService) Attachment(message, id snowflake.ID) (*shared.Attachment, error) {
+	query := "SELECT * FROM attachments WHERE id = $1 AND message = $2"
+
+	attachment, err := rowToAttachment(service.pool.QueryRow(context.Background(), query, id, message))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// CreateOrReplace creates or replaces an attachment inside the database
+func (service *attachmentService) CreateOrReplace(attachment *shared.Attachment) error {
+	query := `
+		INSERT INTO attachments (id, message, filename, content_type, size, content_id, data, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE
+			SET message = excluded.message,
+				filename = excluded.filename,
+				content_type = excluded.content_type,
+				size = excluded.size,
+				content_id = excluded.content_id,
+				data = excluded.data,
+				created = excluded.created
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, attachment.ID, attachment.Message, attachment.Filename, attachment.ContentType, attachment.Size, attachment.ContentID, attachment.Data, attachment.Created)
+	return err
+}
+
+func rowToAttachment(row pgx.Row) (*shared.Attachment, error) {
+	attachment := new(shared.Attachment)
+
+	if err := row.Scan(&attachment.ID, &attachment.Message, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.ContentID, &attachment.Data, &attachment.Created); err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// domainColumns holds the columns selected when retrieving domains including the amount of their mailboxes
+const domainColumns = `name, enabled, public, created, owner, verification_token, verified, (SELECT COUNT(*) FROM mailboxes WHERE split_part(mailboxes.address, '@', 2) = domains.name)`
+
+// domainService represents the postgres domain service implementation
+type domainService struct {
+	pool *pgxpool.Pool
+}
+
+// Domains retrieves all domains out of the database
+func (service *domainService) Domains() ([]*shared.Domain, error) {
+	query := fmt.Sprintf("SELECT %s FROM domains ORDER BY name", domainColumns)
+
+	return service.query(query)
+}
+
+// Enabled retrieves all enabled domains out of the database
+func (service *domainService) Enabled() ([]*shared.Domain, error) {
+	query := fmt.Sprintf("SELECT %s FROM domains WHERE enabled ORDER BY name", domainColumns)
+
+	return service.query(query)
+}
+
+// CountOwnedBy counts the total amount of domains owned by a specific account stored inside the database
+func (service *domainService) CountOwnedBy(owner snowflake.ID) (int, error) {
+	query := "SELECT COUNT(*) FROM domains WHERE owner = $1"
+
+	row := service.pool.QueryRow(context.Background(), query, owner)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// OwnedBy retrieves all domains owned by a specific account out of the database
+func (service *domainService) OwnedBy(owner snowflake.ID) ([]*shared.Domain, error) {
+	query := fmt.Sprintf("SELECT %s FROM domains WHERE owner = $1 ORDER BY name", domainColumns)
+
+	return service.query(query, owner)
+}
+
+func (service *domainService) query(query string, args ...interface{}) ([]*shared.Domain, error) {
+	rows, err := service.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.Domain{}, nil
+		}
+		return nil, err
+	}
+
+	domains := []*shared.Domain{}
+	for rows.Next() {
+		domain, err := rowToDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, nil
+}
+
+// Domain retrieves a specific domain with a specific name out of the database
+func (service *domainService) Domain(name string) (*shared.Domain, error) {
+	query := "SELECT " + domainColumns + " FROM domains WHERE name = $1"
+
+	domain, err := rowToDomain(service.pool.QueryRow(context.Background(), query, strings.ToLower(name)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+// CreateOrReplace creates or replaces a domain inside the database
+func (service *domainService) CreateOrReplace(domain *shared.Domain) error {
+	query := `
+		INSERT INTO domains (name, enabled, public, created, owner, verification_token, verified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (name) DO UPDATE
+			SET enabled = excluded.enabled,
+				public = excluded.public,
+				created = excluded.created,
+				owner = excluded.owner,
+				verification_token = excluded.verification_token,
+				verified = excluded.verified
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(domain.Name), domain.Enabled, domain.Public, domain.Created, domain.Owner, domain.VerificationToken, domain.Verified)
+	return err
+}
+
+// Delete deletes a specific domain with a specific name out of the database
+func (service *domainService) Delete(name string) error {
+	query := "DELETE FROM domains WHERE name = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(name))
+	return err
+}
+
+func rowToDomain(row pgx.Row) (*shared.Domain, error) {
+	domain := new(shared.Domain)
+
+	if err := row.Scan(&domain.Name, &domain.Enabled, &domain.Public, &domain.Created, &domain.Owner, &domain.VerificationToken, &domain.Verified, &domain.Mailboxes); err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
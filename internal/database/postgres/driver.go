@@ -23,6 +23,12 @@ type postgresDriver struct {
 	Invites       *inviteService
 	Mailboxes     *mailboxService
 	Messages      *messageService
+	Attachments   *attachmentService
+	Domains       *domainService
+	Members       *mailboxMemberService
+	Transfers     *mailboxTransferService
+	Tokens        *personalAccessTokenService
+	TwoFactor     *twoFactorService
 }
 
 // NewDriver creates a new postgres database driver
@@ -42,6 +48,12 @@ func NewDriver(dsn string) (*postgresDriver, error) {
 		Invites:       &inviteService{pool: pool},
 		Mailboxes:     &mailboxService{pool: pool},
 		Messages:      &messageService{pool: pool},
+		Attachments:   &attachmentService{pool: pool},
+		Domains:       &domainService{pool: pool},
+		Members:       &mailboxMemberService{pool: pool},
+		Transfers:     &mailboxTransferService{pool: pool},
+		Tokens:        &personalAccessTokenService{pool: pool},
+		TwoFactor:     &twoFactorService{pool: pool},
 	}, nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/jackc/pgx/v4"
@@ -93,6 +94,61 @@ func (service *mailboxService) MailboxesInAccount(account snowflake.ID, skip, li
 	return mailboxes, nil
 }
 
+// accessibleMailboxCondition represents the condition matching all mailboxes owned by or shared with the account given as $1
+const accessibleMailboxCondition = "account = $1 OR address IN (SELECT mailbox FROM mailbox_members WHERE account = $1)"
+
+// CountAccessible counts the total amount of mailboxes a specific account owns or is a member of stored inside the database
+func (service *mailboxService) CountAccessible(account snowflake.ID) (int, error) {
+	query := "SELECT COUNT(*) FROM mailboxes WHERE " + accessibleMailboxCondition
+
+	row := service.pool.QueryRow(context.Background(), query, account)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// MailboxesAccessible retrieves the desired amount of mailboxes a specific account owns or is a member of out of the database
+// The roles of the account are returned by the addresses of the mailboxes which got shared with it
+func (service *mailboxService) MailboxesAccessible(account snowflake.ID, skip, limit int) ([]*shared.Mailbox, map[string]shared.MailboxRole, error) {
+	query := fmt.Sprintf(`
+		SELECT mailboxes.*, COALESCE(mailbox_members.role, '')
+		FROM mailboxes
+			LEFT JOIN mailbox_members ON mailbox_members.mailbox = mailboxes.address AND mailbox_members.account = $1
+		WHERE mailboxes.account = $1 OR mailbox_members.account IS NOT NULL
+		ORDER BY mailboxes.created
+		LIMIT %d OFFSET %d
+	`, limit, skip)
+
+	rows, err := service.pool.Query(context.Background(), query, account)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.Mailbox{}, map[string]shared.MailboxRole{}, nil
+		}
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var mailboxes []*shared.Mailbox
+	roles := make(map[string]shared.MailboxRole)
+	for rows.Next() {
+		mailbox := new(shared.Mailbox)
+		var role string
+		if err := rows.Scan(&mailbox.Address, &mailbox.Account, &mailbox.Created, &mailbox.Expires, &mailbox.Retention, &mailbox.Pattern, &role); err != nil {
+			return nil, nil, err
+		}
+		mailboxes = append(mailboxes, mailbox)
+		if mailbox.Account != account && role != "" {
+			roles[mailbox.Address] = shared.MailboxRole(role)
+		}
+	}
+
+	return mailboxes, roles, rows.Err()
+}
+
 // Mailbox retrieves a specific mailbox with a specific address out of the database
 func (service *mailboxService) Mailbox(address string) (*shared.Mailbox, error) {
 	query := "SELECT * FROM mailboxes WHERE address = $1"
@@ -108,17 +164,83 @@ func (service *mailboxService) Mailbox(address string) (*shared.Mailbox, error)
 	return mailbox, nil
 }
 
-// CreateOrReplace creates or replaces a mailbox inside the database
-func (service *mailboxService) CreateOrReplace(mailbox *shared.Mailbox) error {
+// MatchPattern retrieves the most specific pattern mailbox matching a specific address out of the database
+// The wildcards of the patterns are translated into LIKE wildcards so that only the pattern mailboxes of the domain
+// of the address have to be looked at; patterns with more literal characters are considered more specific
+func (service *mailboxService) MatchPattern(address string) (*shared.Mailbox, error) {
+	address = strings.ToLower(address)
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return nil, nil
+	}
+	local, domain := address[:at], address[at+1:]
+
+	query := `
+		SELECT * FROM mailboxes
+		WHERE pattern
+			AND split_part(address, '@', 2) = $2
+			AND $1 LIKE replace(replace(replace(split_part(address, '@', 1), '_', '\_'), '*', '%'), '?', '_')
+		ORDER BY length(translate(split_part(address, '@', 1), '*?', '')) DESC, address
+		LIMIT 1
+	`
+
+	mailbox, err := rowToMailbox(service.pool.QueryRow(context.Background(), query, local, domain))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return mailbox, nil
+}
+
+// Expired retrieves all mailboxes whose expiry date has passed out of the database
+func (service *mailboxService) Expired() ([]*shared.Mailbox, error) {
+	query := "SELECT * FROM mailboxes WHERE expires IS NOT NULL AND expires <= $1"
+
+	rows, err := service.pool.Query(context.Background(), query, time.Now().Unix())
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.Mailbox{}, nil
+		}
+		return nil, err
+	}
+
+	var mailboxes []*shared.Mailbox
+	for rows.Next() {
+		mailbox, err := rowToMailbox(rows)
+		if err != nil {
+			return nil, err
+		}
+		mailboxes = append(mailboxes, mailbox)
+	}
+
+	return mailboxes, nil
+}
+
+// Create creates a mailbox inside the database if its address is not taken yet
+// The returned boolean reports whether the mailbox was created
+func (service *mailboxService) Create(mailbox *shared.Mailbox) (bool, error) {
 	query := `
-		INSERT INTO mailboxes (address, account, created)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (address) DO UPDATE
-			SET account = excluded.account,
-				created = excluded.created
+		INSERT INTO mailboxes (address, account, created, expires, retention, pattern)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (address) DO NOTHING
 	`
 
-	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(mailbox.Address), mailbox.Account, mailbox.Created)
+	tag, err := service.pool.Exec(context.Background(), query, strings.ToLower(mailbox.Address), mailbox.Account, mailbox.Created, mailbox.Expires, mailbox.Retention, mailbox.Pattern)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UpdateSettings updates the expiry date and retention period of a mailbox inside the database
+// The owner of the mailbox is left untouched so that concurrent transfers are not reverted
+func (service *mailboxService) UpdateSettings(mailbox *shared.Mailbox) error {
+	query := "UPDATE mailboxes SET expires = $2, retention = $3 WHERE address = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(mailbox.Address), mailbox.Expires, mailbox.Retention)
 	return err
 }
 
@@ -138,10 +260,18 @@ func (service *mailboxService) DeleteInAccount(account snowflake.ID) error {
 	return err
 }
 
+// DeleteInDomain deletes all mailboxes on a specific domain out of the database
+func (service *mailboxService) DeleteInDomain(domain string) error {
+	query := "DELETE FROM mailboxes WHERE split_part(address, '@', 2) = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(domain))
+	return err
+}
+
 func rowToMailbox(row pgx.Row) (*shared.Mailbox, error) {
 	mailbox := new(shared.Mailbox)
 
-	if err := row.Scan(&mailbox.Address, &mailbox.Account, &mailbox.Created); err != nil {
+	if err := row.Scan(&mailbox.Address, &mailbox.Account, &mailbox.Created, &mailbox.Expires, &mailbox.Retention, &mailbox.Pattern); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// mailboxMemberService represents the postgres mailbox member service implementation
+type mailboxMemberService struct {
+	pool *pgxpool.Pool
+}
+
+// Members retrieves all members of a specific mailbox out of the database
+func (service *mailboxMemberService) Members(mailbox string) ([]*shared.MailboxMember, error) {
+	query := "SELECT * FROM mailbox_members WHERE mailbox = $1 ORDER BY created"
+
+	rows, err := service.pool.Query(context.Background(), query, strings.ToLower(mailbox))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.MailboxMember{}, nil
+		}
+		return nil, err
+	}
+
+	members := []*shared.MailboxMember{}
+	for rows.Next() {
+		member, err := rowToMailboxMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// Member retrieves a specific member of a specific mailbox out of the database
+func (service *mailboxMemberService) Member(mailbox string, account snowflake.ID) (*shared.MailboxMember, error) {
+	query := "SELECT * FROM mailbox_members WHERE mailbox = $1 AND account = $2"
+
+	member, err := rowToMailboxMember(service.pool.QueryRow(context.Background(), query, strings.ToLower(mailbox), account))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// CreateOrReplace creates or replaces a mailbox member inside the database
+func (service *mailboxMemberService) CreateOrReplace(member *shared.MailboxMember) error {
+	query := `
+		INSERT INTO mailbox_members (mailbox, account, role, created)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (mailbox, account) DO UPDATE
+			SET role = excluded.role,
+				created = excluded.created
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(member.Mailbox), member.Account, member.Role, member.Created)
+	return err
+}
+
+// Delete deletes a specific member of a specific mailbox out of the database
+func (service *mailboxMemberService) Delete(mailbox string, account snowflake.ID) error {
+	query := "DELETE FROM mailbox_members WHERE mailbox = $1 AND account = $2"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(mailbox), account)
+	return err
+}
+
+// DeleteInAccount deletes all mailbox memberships of a specific account out of the database
+func (service *mailboxMemberService) DeleteInAccount(account snowflake.ID) error {
+	query := "DELETE FROM mailbox_members WHERE account = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, account)
+	return err
+}
+
+func rowToMailboxMember(row pgx.Row) (*shared.MailboxMember, error) {
+	member := new(shared.MailboxMember)
+
+	if err := row.Scan(&member.Mailbox, &member.Account, &member.Role, &member.Created); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
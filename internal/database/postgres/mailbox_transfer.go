@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// mailboxTransferService represents the postgres mailbox transfer service implementation
+type mailboxTransferService struct {
+	pool *pgxpool.Pool
+}
+
+// Transfer retrieves the pending transfer of a specific mailbox out of the database
+func (service *mailboxTransferService) Transfer(mailbox string) (*shared.MailboxTransfer, error) {
+	query := "SELECT * FROM mailbox_transfers WHERE mailbox = $1"
+
+	transfer, err := rowToMailboxTransfer(service.pool.QueryRow(context.Background(), query, strings.ToLower(mailbox)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// TransfersTo retrieves all pending transfers to a specific account out of the database
+func (service *mailboxTransferService) TransfersTo(account snowflake.ID) ([]*shared.MailboxTransfer, error) {
+	query := "SELECT * FROM mailbox_transfers WHERE to_account = $1 ORDER BY created"
+
+	rows, err := service.pool.Query(context.Background(), query, account)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.MailboxTransfer{}, nil
+		}
+		return nil, err
+	}
+
+	transfers := []*shared.MailboxTransfer{}
+	for rows.Next() {
+		transfer, err := rowToMailboxTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+// CreateOrReplace creates or replaces the pending transfer of a mailbox inside the database
+func (service *mailboxTransferService) CreateOrReplace(transfer *shared.MailboxTransfer) error {
+	query := `
+		INSERT INTO mailbox_transfers (mailbox, from_account, to_account, created)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (mailbox) DO UPDATE
+			SET from_account = excluded.from_account,
+				to_account = excluded.to_account,
+				created = excluded.created
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(transfer.Mailbox), transfer.From, transfer.To, transfer.Created)
+	return err
+}
+
+// Execute atomically hands the given mailbox over to the account it references
+// The new owner loses its membership of the mailbox and the pending transfer of the mailbox gets deleted
+func (service *mailboxTransferService) Execute(mailbox *shared.Mailbox) error {
+	address := strings.ToLower(mailbox.Address)
+	return service.pool.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), "UPDATE mailboxes SET account = $2, expires = $3 WHERE address = $1", address, mailbox.Account, mailbox.Expires); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(context.Background(), "DELETE FROM mailbox_members WHERE mailbox = $1 AND account = $2", address, mailbox.Account); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), "DELETE FROM mailbox_transfers WHERE mailbox = $1", address)
+		return err
+	})
+}
+
+// Delete deletes the pending transfer of a specific mailbox out of the database
+func (service *mailboxTransferService) Delete(mailbox string) error {
+	query := "DELETE FROM mailbox_transfers WHERE mailbox = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(mailbox))
+	return err
+}
+
+// DeleteInAccount deletes all pending transfers from or to a specific account out of the database
+func (service *mailboxTransferService) DeleteInAccount(account snowflake.ID) error {
+	query := "DELETE FROM mailbox_transfers WHERE from_account = $1 OR to_account = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, account)
+	return err
+}
+
+func rowToMailboxTransfer(row pgx.Row) (*shared.MailboxTransfer, error) {
+	transfer := new(shared.MailboxTransfer)
+
+	if err := row.Scan(&transfer.Mailbox, &transfer.From, &transfer.To, &transfer.Created); err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
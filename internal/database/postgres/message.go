@@ -1,10 +1,15 @@
 package postgres
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"html"
+	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/jackc/pgx/v4"
@@ -12,16 +17,21 @@ import (
 	"github.com/poopmail/canalization/internal/shared"
 )
 
+// messageColumns holds the columns selected when retrieving messages
+// The generated search column is deliberately left out
+const messageColumns = `id, mailbox, "from", subject, content_plain, content_html, created, headers, seen, flagged, archived, tag, recipient`
+
 // messageService represents the postgres message service implementation
 type messageService struct {
 	pool *pgxpool.Pool
 }
 
-// Count counts the total amount of messages in a specific mailbox stored inside the database
-func (service *messageService) Count(mailbox string) (int, error) {
-	query := "SELECT COUNT(*) FROM messages WHERE mailbox = $1"
+// Count counts the total amount of messages matching a specific filter stored inside the database
+func (service *messageService) Count(filter *shared.MessageFilter) (int, error) {
+	conditions, args := messageFilterToConditions(filter)
+	query := "SELECT COUNT(*) FROM messages WHERE " + conditions
 
-	row := service.pool.QueryRow(context.Background(), query, strings.ToLower(mailbox))
+	row := service.pool.QueryRow(context.Background(), query, args...)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -31,11 +41,12 @@ func (service *messageService) Count(mailbox string) (int, error) {
 	return count, nil
 }
 
-// Messages retrieves the desired amount of messages in a specific mailbox out of the database
-func (service *messageService) Messages(mailbox string, skip, limit int) ([]*shared.Message, error) {
-	query := fmt.Sprintf("SELECT * FROM messages WHERE mailbox = $1 ORDER BY created LIMIT %d OFFSET %d", limit, skip)
+// Messages retrieves the desired amount of messages matching a specific filter out of the database
+func (service *messageService) Messages(filter *shared.MessageFilter, skip, limit int) ([]*shared.Message, error) {
+	conditions, args := messageFilterToConditions(filter)
+	query := fmt.Sprintf("SELECT %s FROM messages WHERE %s ORDER BY created, id LIMIT %d OFFSET %d", messageColumns, conditions, limit, skip)
 
-	rows, err := service.pool.Query(context.Background(), query, strings.ToLower(mailbox))
+	rows, err := service.pool.Query(context.Background(), query, args...)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return []*shared.Message{}, nil
@@ -55,9 +66,77 @@ func (service *messageService) Messages(mailbox string, skip, limit int) ([]*sha
 	return messages, nil
 }
 
+// CountSearch counts the total amount of messages matching a specific full-text search stored inside the database
+func (service *messageService) CountSearch(search *shared.MessageSearch) (int, error) {
+	conditions, args := messageSearchToConditions(search)
+	query := "SELECT COUNT(*) FROM messages, websearch_to_tsquery('simple', $1) query WHERE " + conditions
+
+	row := service.pool.QueryRow(context.Background(), query, args...)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Search retrieves the desired amount of messages matching a specific full-text search out of the database
+// The results are ordered by their rank and contain highlighted snippets of the subject and plain content
+func (service *messageService) Search(search *shared.MessageSearch, skip, limit int) ([]*shared.MessageSearchResult, error) {
+	conditions, args := messageSearchToConditions(search)
+	query := fmt.Sprintf(`
+		SELECT %s,
+			ts_rank(search, query) AS rank,
+			ts_headline('simple', translate(subject, chr(2) || chr(3), ''), query, 'StartSel="' || chr(2) || '", StopSel="' || chr(3) || '", HighlightAll=true'),
+			ts_headline('simple', translate(content_plain, chr(2) || chr(3), ''), query, 'StartSel="' || chr(2) || '", StopSel="' || chr(3) || '", MaxFragments=3, MaxWords=20, MinWords=5')
+		FROM messages, websearch_to_tsquery('simple', $1) query
+		WHERE %s
+		ORDER BY rank DESC, created DESC, id DESC
+		LIMIT %d OFFSET %d
+	`, messageColumns, conditions, limit, skip)
+
+	rows, err := service.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.MessageSearchResult{}, nil
+		}
+		return nil, err
+	}
+
+	results := []*shared.MessageSearchResult{}
+	for rows.Next() {
+		message := new(shared.Message)
+		message.Content = new(shared.MessageContent)
+		message.Headers = new(shared.MessageHeaders)
+		result := &shared.MessageSearchResult{
+			Message:    message,
+			Highlights: new(shared.MessageHighlights),
+		}
+
+		if err := rows.Scan(&message.ID, &message.Mailbox, &message.From, &message.Subject, &message.Content.Plain, &message.Content.HTML, &message.Created, message.Headers, &message.Seen, &message.Flagged, &message.Archived, &message.Tag, &message.Recipient, &result.Rank, &result.Highlights.Subject, &result.Highlights.Content); err != nil {
+			return nil, err
+		}
+		result.Highlights.Subject = renderHighlight(result.Highlights.Subject)
+		result.Highlights.Content = renderHighlight(result.Highlights.Content)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// renderHighlight HTML-escapes the given highlighted text and turns the control character delimiters produced by
+// ts_headline into <mark> tags
+// The text originates from untrusted senders, so it must never be wrapped in markup without escaping it first.
+func renderHighlight(text string) string {
+	return highlightReplacer.Replace(html.EscapeString(text))
+}
+
+var highlightReplacer = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
 // Message retrieves a specific message with a specific ID out of the database
 func (service *messageService) Message(id snowflake.ID) (*shared.Message, error) {
-	query := "SELECT * FROM messages WHERE id = $1"
+	query := "SELECT " + messageColumns + " FROM messages WHERE id = $1"
 
 	message, err := rowToMessage(service.pool.QueryRow(context.Background(), query, id))
 	if err != nil {
@@ -70,21 +149,180 @@ func (service *messageService) Message(id snowflake.ID) (*shared.Message, error)
 	return message, nil
 }
 
-// CreateOrReplace creates or replaces a message inside the database
-func (service *messageService) CreateOrReplace(message *shared.Message) error {
+// Create creates a message inside the database if its mailbox did not receive a message of the same delivery yet
+// The returned boolean reports whether the message was created
+func (service *messageService) Create(message *shared.Message) (bool, error) {
+	query := `
+		INSERT INTO messages (id, mailbox, "from", subject, content_plain, content_html, created, headers, seen, flagged, archived, tag, recipient, delivery)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''))
+		ON CONFLICT (mailbox, delivery) DO NOTHING
+	`
+
+	headers := message.Headers
+	if headers == nil {
+		headers = new(shared.MessageHeaders)
+	}
+
+	tag, err := service.pool.Exec(context.Background(), query, message.ID, strings.ToLower(message.Mailbox), message.From, message.Subject, message.Content.Plain, message.Content.HTML, message.Created, headers, message.Seen, message.Flagged, message.Archived, strings.ToLower(message.Tag), strings.ToLower(message.Recipient), message.Delivery)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UpdateFlags updates the flags of all messages with the given IDs inside the database
+// Flags which are nil are left untouched
+func (service *messageService) UpdateFlags(ids []snowflake.ID, flags *shared.MessageFlags) error {
 	query := `
-		INSERT INTO messages (id, mailbox, "from", subject, content_plain, content_html, created)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (id) DO UPDATE
-			SET mailbox = excluded.mailbox,
-				"from" = excluded.from,
-				subject = excluded.subject,
-				content_plain = excluded.content_plain,
-				content_html = excluded.content_html,
-				created = excluded.created
+		UPDATE messages
+			SET seen = COALESCE($2, seen),
+				flagged = COALESCE($3, flagged),
+				archived = COALESCE($4, archived)
+		WHERE id = ANY($1)
 	`
 
-	_, err := service.pool.Exec(context.Background(), query, message.ID, strings.ToLower(message.Mailbox), message.From, message.Subject, message.Content.Plain, message.Content.HTML, message.Created)
+	rawIDs := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		rawIDs = append(rawIDs, id.Int64())
+	}
+
+	_, err := service.pool.Exec(context.Background(), query, rawIDs, flags.Seen, flags.Flagged, flags.Archived)
+	return err
+}
+
+// MessageMailboxes retrieves the mailbox addresses of the given messages out of the database
+// Messages which do not exist are left out of the returned map
+func (service *messageService) MessageMailboxes(ids []snowflake.ID) (map[snowflake.ID]string, error) {
+	query := "SELECT id, mailbox FROM messages WHERE id = ANY($1)"
+
+	rawIDs := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		rawIDs = append(rawIDs, id.Int64())
+	}
+
+	rows, err := service.pool.Query(context.Background(), query, rawIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mailboxes := make(map[snowflake.ID]string, len(ids))
+	for rows.Next() {
+		var id snowflake.ID
+		var mailbox string
+		if err := rows.Scan(&id, &mailbox); err != nil {
+			return nil, err
+		}
+		mailboxes[id] = mailbox
+	}
+
+	return mailboxes, rows.Err()
+}
+
+// CountUnseen counts the amount of unseen messages in each of the given mailboxes stored inside the database
+func (service *messageService) CountUnseen(mailboxes []string) (map[string]int, error) {
+	query := "SELECT mailbox, COUNT(*) FROM messages WHERE mailbox = ANY($1) AND NOT seen GROUP BY mailbox"
+
+	lowered := make([]string, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		lowered = append(lowered, strings.ToLower(mailbox))
+	}
+
+	rows, err := service.pool.Query(context.Background(), query, lowered)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(mailboxes))
+	for rows.Next() {
+		var mailbox string
+		var count int
+		if err := rows.Scan(&mailbox, &count); err != nil {
+			return nil, err
+		}
+		counts[mailbox] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// DeleteRetained deletes a single batch of messages which exceeded their retention period out of the database
+// The retention period of a message is defined by its mailbox, its account or the given fallback, in that order;
+// a retention period of zero keeps messages forever. The amount of deleted messages is returned per mailbox.
+func (service *messageService) DeleteRetained(fallback time.Duration, batchSize int) (map[string]int64, error) {
+	query := `
+		WITH retained AS (
+			SELECT messages.id
+			FROM messages
+				JOIN mailboxes ON mailboxes.address = messages.mailbox
+				JOIN accounts ON accounts.id = mailboxes.account
+			WHERE COALESCE(mailboxes.retention, accounts.retention, $1) > 0
+				AND messages.created < $2 - COALESCE(mailboxes.retention, accounts.retention, $1)
+			LIMIT $3
+		)
+		DELETE FROM messages WHERE id IN (SELECT id FROM retained)
+		RETURNING mailbox
+	`
+
+	rows, err := service.pool.Query(context.Background(), query, int64(fallback.Seconds()), time.Now().Unix(), batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := make(map[string]int64)
+	for rows.Next() {
+		var mailbox string
+		if err := rows.Scan(&mailbox); err != nil {
+			return nil, err
+		}
+		deleted[mailbox]++
+	}
+
+	return deleted, rows.Err()
+}
+
+// Raw retrieves the raw source of a specific message out of the database
+// The source is stored compressed and gets decompressed transparently
+func (service *messageService) Raw(id snowflake.ID) ([]byte, error) {
+	query := "SELECT data FROM raw_messages WHERE message = $1"
+
+	var compressed []byte
+	if err := service.pool.QueryRow(context.Background(), query, id).Scan(&compressed); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// CreateOrReplaceRaw creates or replaces the raw source of a specific message inside the database
+func (service *messageService) CreateOrReplaceRaw(id snowflake.ID, raw []byte) error {
+	query := `
+		INSERT INTO raw_messages (message, data)
+		VALUES ($1, $2)
+		ON CONFLICT (message) DO UPDATE
+			SET data = excluded.data
+	`
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(raw); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	_, err := service.pool.Exec(context.Background(), query, id, compressed.Bytes())
 	return err
 }
 
@@ -104,11 +342,87 @@ func (service *messageService) DeleteInMailbox(mailbox string) error {
 	return err
 }
 
+// DeleteInDomain deletes all messages in mailboxes on a specific domain out of the database
+func (service *messageService) DeleteInDomain(domain string) error {
+	query := "DELETE FROM messages WHERE split_part(mailbox, '@', 2) = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, strings.ToLower(domain))
+	return err
+}
+
+// likeEscaper escapes all characters with a special meaning inside LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// messageFilterToConditions builds the SQL conditions and their arguments representing the given message filter
+func messageFilterToConditions(filter *shared.MessageFilter) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Mailbox != "" {
+		add("mailbox = $%d", strings.ToLower(filter.Mailbox))
+	}
+	if filter.After != 0 {
+		add("id > $%d", filter.After)
+	}
+	if filter.Subject != "" {
+		add("subject ILIKE '%%' || $%d || '%%'", likeEscaper.Replace(filter.Subject))
+	}
+	if filter.From != "" {
+		add("headers @> $%d", map[string]interface{}{"from": map[string]string{"address": strings.ToLower(filter.From)}})
+	}
+	if filter.To != "" {
+		add("headers @> $%d", map[string]interface{}{"to": []map[string]string{{"address": strings.ToLower(filter.To)}}})
+	}
+	if filter.MessageID != "" {
+		add("headers @> $%d", map[string]string{"message_id": strings.Trim(filter.MessageID, "<>")})
+	}
+	if filter.InReplyTo != "" {
+		add("headers @> $%d", map[string]string{"in_reply_to": strings.Trim(filter.InReplyTo, "<>")})
+	}
+	if filter.Tag != "" {
+		add("tag = $%d", strings.ToLower(filter.Tag))
+	}
+	if filter.Seen != nil {
+		add("seen = $%d", *filter.Seen)
+	}
+	if filter.Flagged != nil {
+		add("flagged = $%d", *filter.Flagged)
+	}
+	if filter.Archived != nil {
+		add("archived = $%d", *filter.Archived)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// messageSearchToConditions builds the SQL conditions and their arguments representing the given full-text search
+// The search query itself is always passed as the first argument
+func messageSearchToConditions(search *shared.MessageSearch) (string, []interface{}) {
+	conditions := []string{"search @@ query"}
+	args := []interface{}{search.Query}
+
+	if search.Mailbox != "" {
+		args = append(args, strings.ToLower(search.Mailbox))
+		conditions = append(conditions, fmt.Sprintf("mailbox = $%d", len(args)))
+	}
+	if search.Account != nil {
+		args = append(args, *search.Account)
+		conditions = append(conditions, fmt.Sprintf("mailbox IN (SELECT address FROM mailboxes WHERE account = $%[1]d UNION SELECT mailbox FROM mailbox_members WHERE account = $%[1]d)", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
 func rowToMessage(row pgx.Row) (*shared.Message, error) {
 	message := new(shared.Message)
 	message.Content = new(shared.MessageContent)
+	message.Headers = new(shared.MessageHeaders)
 
-	if err := row.Scan(&message.ID, &message.Mailbox, &message.From, &message.Subject, &message.Content.Plain, &message.Content.HTML, &message.Created); err != nil {
+	if err := row.Scan(&message.ID, &message.Mailbox, &message.From, &message.Subject, &message.Content.Plain, &message.Content.HTML, &message.Created, message.Headers, &message.Seen, &message.Flagged, &message.Archived, &message.Tag, &message.Recipient); err != nil {
 		return nil, err
 	}
 
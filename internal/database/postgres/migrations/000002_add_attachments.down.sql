@@ -0,0 +1,5 @@
+begin;
+
+drop table if exists attachments;
+
+commit;
\ No newline at end of file
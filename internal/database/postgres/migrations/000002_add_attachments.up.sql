@@ -0,0 +1,17 @@
+begin;
+
+create table if not exists attachments (
+    "id" bigint not null,
+    "message" bigint not null references messages ("id") on delete cascade,
+    "filename" text not null,
+    "content_type" text not null,
+    "size" bigint not null,
+    "content_id" text not null,
+    "data" bytea not null,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("id")
+);
+
+create index if not exists attachments_message_idx on attachments ("message");
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+drop table if exists raw_messages;
+
+commit;
\ No newline at end of file
@@ -0,0 +1,9 @@
+begin;
+
+create table if not exists raw_messages (
+    "message" bigint not null references messages ("id") on delete cascade,
+    "data" bytea not null,
+    primary key ("message")
+);
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists messages_headers_idx;
+
+alter table messages drop column if exists "headers";
+
+commit;
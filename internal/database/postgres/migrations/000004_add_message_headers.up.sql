@@ -0,0 +1,7 @@
+begin;
+
+alter table messages add column if not exists "headers" jsonb not null default '{}'::jsonb;
+
+create index if not exists messages_headers_idx on messages using gin ("headers" jsonb_path_ops);
+
+commit;
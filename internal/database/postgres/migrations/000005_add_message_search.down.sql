@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists messages_search_idx;
+
+alter table messages drop column if exists "search";
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+alter table messages add column if not exists "search" tsvector generated always as (
+    setweight(to_tsvector('simple', coalesce("subject", '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce("from", '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce("content_plain", '')), 'C')
+) stored;
+
+create index if not exists messages_search_idx on messages using gin ("search");
+
+commit;
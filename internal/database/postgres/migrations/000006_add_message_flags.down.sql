@@ -0,0 +1,9 @@
+begin;
+
+drop index if exists messages_unseen_idx;
+
+alter table messages drop column if exists "archived";
+alter table messages drop column if exists "flagged";
+alter table messages drop column if exists "seen";
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table messages add column if not exists "seen" bool not null default false;
+alter table messages add column if not exists "flagged" bool not null default false;
+alter table messages add column if not exists "archived" bool not null default false;
+
+create index if not exists messages_unseen_idx on messages ("mailbox") where not "seen";
+
+commit;
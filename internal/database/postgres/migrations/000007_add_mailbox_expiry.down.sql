@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists mailboxes_expires_idx;
+
+alter table mailboxes drop column if exists "expires";
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+alter table mailboxes add column if not exists "expires" bigint;
+
+create index if not exists mailboxes_expires_idx on mailboxes ("expires") where "expires" is not null;
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+drop index if exists messages_created_idx;
+
+alter table mailboxes drop column if exists "retention";
+
+alter table accounts drop column if exists "retention";
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table accounts add column if not exists "retention" bigint;
+
+alter table mailboxes add column if not exists "retention" bigint;
+
+create index if not exists messages_created_idx on messages ("created");
+
+commit;
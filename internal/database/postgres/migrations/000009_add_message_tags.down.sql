@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists messages_mailbox_tag_idx;
+
+alter table messages drop column if exists "tag";
+
+commit;
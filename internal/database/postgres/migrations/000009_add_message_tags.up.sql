@@ -0,0 +1,7 @@
+begin;
+
+alter table messages add column if not exists "tag" text not null default '';
+
+create index if not exists messages_mailbox_tag_idx on messages ("mailbox", "tag") where "tag" <> '';
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table messages drop column if exists "recipient";
+
+drop index if exists mailboxes_pattern_idx;
+
+alter table mailboxes drop column if exists "pattern";
+
+commit;
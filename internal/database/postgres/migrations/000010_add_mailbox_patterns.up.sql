@@ -0,0 +1,9 @@
+begin;
+
+alter table mailboxes add column if not exists "pattern" boolean not null default false;
+
+create index if not exists mailboxes_pattern_idx on mailboxes ("address") where "pattern";
+
+alter table messages add column if not exists "recipient" text not null default '';
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists mailboxes_domain_idx;
+
+drop table if exists domains;
+
+commit;
@@ -0,0 +1,13 @@
+begin;
+
+create table if not exists domains (
+    "name" text not null,
+    "enabled" bool not null default true,
+    "public" bool not null default true,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("name")
+);
+
+create index if not exists mailboxes_domain_idx on mailboxes (split_part("address", '@', 2));
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+drop index if exists domains_owner_idx;
+
+alter table domains drop column if exists "verified";
+
+alter table domains drop column if exists "verification_token";
+
+alter table domains drop column if exists "owner";
+
+commit;
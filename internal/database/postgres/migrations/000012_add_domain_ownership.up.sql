@@ -0,0 +1,11 @@
+begin;
+
+alter table domains add column if not exists "owner" bigint;
+
+alter table domains add column if not exists "verification_token" text not null default '';
+
+alter table domains add column if not exists "verified" bool not null default true;
+
+create index if not exists domains_owner_idx on domains ("owner");
+
+commit;
@@ -0,0 +1,13 @@
+begin;
+
+create table if not exists mailbox_members (
+    "mailbox" text not null references mailboxes ("address") on delete cascade,
+    "account" bigint not null,
+    "role" text not null,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("mailbox", "account")
+);
+
+create index if not exists mailbox_members_account_idx on mailbox_members ("account");
+
+commit;
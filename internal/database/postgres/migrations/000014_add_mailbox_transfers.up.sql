@@ -0,0 +1,13 @@
+begin;
+
+create table if not exists mailbox_transfers (
+    "mailbox" text not null references mailboxes ("address") on delete cascade,
+    "from_account" bigint not null,
+    "to_account" bigint not null,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("mailbox")
+);
+
+create index if not exists mailbox_transfers_to_account_idx on mailbox_transfers ("to_account");
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+drop table if exists personal_access_tokens;
+
+commit;
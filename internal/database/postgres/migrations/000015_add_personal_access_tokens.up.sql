@@ -0,0 +1,17 @@
+begin;
+
+create table if not exists personal_access_tokens (
+    "id" bigint not null,
+    "account" bigint not null,
+    "name" text not null,
+    "token" text not null,
+    "scopes" text[] not null,
+    "expires" bigint,
+    "last_used" bigint,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("id")
+);
+
+create index if not exists personal_access_tokens_account_idx on personal_access_tokens ("account");
+
+commit;
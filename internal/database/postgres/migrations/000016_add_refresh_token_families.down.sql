@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists refresh_tokens_family_idx;
+alter table refresh_tokens drop column if exists "consumed";
+alter table refresh_tokens drop column if exists "family";
+
+commit;
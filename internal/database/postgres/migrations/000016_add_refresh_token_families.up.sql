@@ -0,0 +1,10 @@
+begin;
+
+alter table refresh_tokens add column if not exists "family" bigint;
+update refresh_tokens set "family" = "id" where "family" is null;
+alter table refresh_tokens alter column "family" set not null;
+alter table refresh_tokens add column if not exists "consumed" bigint;
+
+create index if not exists refresh_tokens_family_idx on refresh_tokens ("family");
+
+commit;
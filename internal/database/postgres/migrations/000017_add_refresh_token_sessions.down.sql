@@ -0,0 +1,9 @@
+begin;
+
+alter table refresh_tokens drop column if exists "last_used_ip";
+alter table refresh_tokens drop column if exists "last_used";
+alter table refresh_tokens drop column if exists "device";
+alter table refresh_tokens drop column if exists "user_agent";
+alter table refresh_tokens drop column if exists "created_ip";
+
+commit;
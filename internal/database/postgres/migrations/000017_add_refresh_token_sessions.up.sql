@@ -0,0 +1,9 @@
+begin;
+
+alter table refresh_tokens add column if not exists "created_ip" text not null default '';
+alter table refresh_tokens add column if not exists "user_agent" text not null default '';
+alter table refresh_tokens add column if not exists "device" text not null default '';
+alter table refresh_tokens add column if not exists "last_used" bigint;
+alter table refresh_tokens add column if not exists "last_used_ip" text not null default '';
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+drop table if exists recovery_codes;
+drop table if exists two_factor;
+
+commit;
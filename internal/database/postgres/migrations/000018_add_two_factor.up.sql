@@ -0,0 +1,22 @@
+begin;
+
+create table if not exists two_factor (
+    "account" bigint not null,
+    "secret" text not null,
+    "confirmed" boolean not null default false,
+    "last_step" bigint not null default 0,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("account")
+);
+
+create table if not exists recovery_codes (
+    "id" bigint not null,
+    "account" bigint not null,
+    "code" text not null,
+    "created" bigint not null default date_part('epoch'::text, now()),
+    primary key ("id")
+);
+
+create index if not exists recovery_codes_account_idx on recovery_codes ("account");
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+drop index if exists mailboxes_pattern_domain_idx;
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+create index if not exists mailboxes_pattern_domain_idx on mailboxes (split_part("address", '@', 2)) where "pattern";
+
+commit;
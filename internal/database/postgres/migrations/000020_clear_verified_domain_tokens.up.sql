@@ -0,0 +1,5 @@
+begin;
+
+update domains set "verification_token" = '' where "verified";
+
+commit;
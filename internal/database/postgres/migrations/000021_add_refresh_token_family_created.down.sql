@@ -0,0 +1,5 @@
+begin;
+
+alter table refresh_tokens drop column if exists "family_created";
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+alter table refresh_tokens add column if not exists "family_created" bigint;
+update refresh_tokens as tokens set "family_created" = (select min("created") from refresh_tokens where "family" = tokens."family") where "family_created" is null;
+alter table refresh_tokens alter column "family_created" set not null;
+
+commit;
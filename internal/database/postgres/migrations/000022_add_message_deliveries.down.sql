@@ -0,0 +1,6 @@
+begin;
+
+drop index if exists messages_delivery_idx;
+alter table messages drop column if exists "delivery";
+
+commit;
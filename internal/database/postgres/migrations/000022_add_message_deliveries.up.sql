@@ -0,0 +1,6 @@
+begin;
+
+alter table messages add column if not exists "delivery" text;
+create unique index if not exists messages_delivery_idx on messages ("mailbox", "delivery");
+
+commit;
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// personalAccessTokenService represents the postgres personal access token service implementation
+type personalAccessTokenService struct {
+	pool *pgxpool.Pool
+}
+
+// Count counts the total amount of personal access tokens of a specific account stored inside the database
+func (service *personalAccessTokenService) Count(account snowflake.ID) (int, error) {
+	query := "SELECT COUNT(*) FROM personal_access_tokens WHERE account = $1"
+
+	row := service.pool.QueryRow(context.Background(), query, account)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// PersonalAccessTokens retrieves the desired amount of personal access tokens of a specific account out of the database
+func (service *personalAccessTokenService) PersonalAccessTokens(account snowflake.ID, skip, limit int) ([]*shared.PersonalAccessToken, error) {
+	query := fmt.Sprintf("SELECT * FROM personal_access_tokens WHERE account = $1 ORDER BY created LIMIT %d OFFSET %d", limit, skip)
+
+	rows, err := service.pool.Query(context.Background(), query, account)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.PersonalAccessToken{}, nil
+		}
+		return nil, err
+	}
+
+	tokens := []*shared.PersonalAccessToken{}
+	for rows.Next() {
+		token, err := rowToPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// PersonalAccessToken retrieves a specific personal access token with a specific ID out of the database
+func (service *personalAccessTokenService) PersonalAccessToken(id snowflake.ID) (*shared.PersonalAccessToken, error) {
+	query := "SELECT * FROM personal_access_tokens WHERE id = $1"
+
+	token, err := rowToPersonalAccessToken(service.pool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// CreateOrReplace creates or replaces a personal access token inside the database
+func (service *personalAccessTokenService) CreateOrReplace(token *shared.PersonalAccessToken) error {
+	query := `
+		INSERT INTO personal_access_tokens (id, account, name, token, scopes, expires, last_used, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE
+			SET account = excluded.account,
+				name = excluded.name,
+				token = excluded.token,
+				scopes = excluded.scopes,
+				expires = excluded.expires,
+				last_used = excluded.last_used,
+				created = excluded.created
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, token.ID, token.Account, token.Name, token.Token, token.Scopes, token.Expires, token.LastUsed, token.Created)
+	return err
+}
+
+// UpdateLastUsed updates the time a specific personal access token was used the last time inside the database
+func (service *personalAccessTokenService) UpdateLastUsed(id snowflake.ID, lastUsed int64) error {
+	query := "UPDATE personal_access_tokens SET last_used = $2 WHERE id = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, id, lastUsed)
+	return err
+}
+
+// Delete deletes a specific personal access token with a specific ID out of the database
+func (service *personalAccessTokenService) Delete(id snowflake.ID) error {
+	query := "DELETE FROM personal_access_tokens WHERE id = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, id)
+	return err
+}
+
+// DeleteAll deletes all personal access tokens of a specific account out of the database
+func (service *personalAccessTokenService) DeleteAll(account snowflake.ID) error {
+	query := "DELETE FROM personal_access_tokens WHERE account = $1"
+
+	_, err := service.pool.Exec(context.Background(), query, account)
+	return err
+}
+
+func rowToPersonalAccessToken(row pgx.Row) (*shared.PersonalAccessToken, error) {
+	token := new(shared.PersonalAccessToken)
+
+	if err := row.Scan(&token.ID, &token.Account, &token.Name, &token.Token, &token.Scopes, &token.Expires, &token.LastUsed, &token.Created); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
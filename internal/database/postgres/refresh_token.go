@@ -17,9 +17,9 @@ type refreshTokenService struct {
 	pool *pgxpool.Pool
 }
 
-// Count counts the total amount of refresh tokens of a specific account stored inside the database
+// Count counts the total amount of unconsumed refresh tokens of a specific account stored inside the database
 func (service *refreshTokenService) Count(account snowflake.ID) (int, error) {
-	query := "SELECT COUNT(*) FROM refresh_tokens WHERE account = $1"
+	query := "SELECT COUNT(*) FROM refresh_tokens WHERE account = $1 AND consumed IS NULL"
 
 	row := service.pool.QueryRow(context.Background(), query, account)
 
@@ -30,9 +30,9 @@ func (service *refreshTokenService) Count(account snowflake.ID) (int, error) {
 	return count, nil
 }
 
-// RefreshTokens retrieves the desired amount of refresh tokens of a specific amount out of the database
+// RefreshTokens retrieves the desired amount of unconsumed refresh tokens of a specific amount out of the database
 func (service *refreshTokenService) RefreshTokens(account snowflake.ID, skip, limit int) ([]*shared.RefreshToken, error) {
-	query := fmt.Sprintf("SELECT * FROM refresh_tokens WHERE account = $1 ORDER BY created LIMIT %d OFFSET %d", limit, skip)
+	query := fmt.Sprintf("SELECT * FROM refresh_tokens WHERE account = $1 AND consumed IS NULL ORDER BY created LIMIT %d OFFSET %d", limit, skip)
 
 	rows, err := service.pool.Query(context.Background(), query, account)
 	if err != nil {
@@ -54,9 +54,9 @@ func (service *refreshTokenService) RefreshTokens(account snowflake.ID, skip, li
 	return refreshTokens, nil
 }
 
-// RefreshToken retrieves a specific refresh token from a specific account out of the database
+// RefreshToken retrieves a specific unconsumed refresh token from a specific account out of the database
 func (service *refreshTokenService) RefreshToken(account, id snowflake.ID) (*shared.RefreshToken, error) {
-	query := "SELECT * FROM refresh_tokens WHERE id = $1 AND account = $2"
+	query := "SELECT * FROM refresh_tokens WHERE id = $1 AND account = $2 AND consumed IS NULL"
 
 	refreshToken, err := rowToRefreshToken(service.pool.QueryRow(context.Background(), query, id, account))
 	if err != nil {
@@ -69,27 +69,87 @@ func (service *refreshTokenService) RefreshToken(account, id snowflake.ID) (*sha
 	return refreshToken, nil
 }
 
+// RefreshTokenByID retrieves a specific refresh token by its ID only out of the database
+// Consumed refresh tokens are included so that their reuse can be detected
+func (service *refreshTokenService) RefreshTokenByID(id snowflake.ID) (*shared.RefreshToken, error) {
+	query := "SELECT * FROM refresh_tokens WHERE id = $1"
+
+	refreshToken, err := rowToRefreshToken(service.pool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return refreshToken, nil
+}
+
 // CreateOrReplace creates or replaces a refresh token inside the database
 func (service *refreshTokenService) CreateOrReplace(token *shared.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (id, account, token, description, created)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO refresh_tokens (id, account, token, description, created, family, consumed, created_ip, user_agent, device, last_used, last_used_ip, family_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		ON CONFLICT (id, account) DO UPDATE
 			SET token = excluded.token,
 				description = excluded.description,
-				created = excluded.created
+				created = excluded.created,
+				family = excluded.family,
+				family_created = excluded.family_created,
+				consumed = excluded.consumed,
+				created_ip = excluded.created_ip,
+				user_agent = excluded.user_agent,
+				device = excluded.device,
+				last_used = excluded.last_used,
+				last_used_ip = excluded.last_used_ip
 	`
 
-	_, err := service.pool.Exec(context.Background(), query, token.ID, token.Account, token.Token, token.Description, token.Created)
+	_, err := service.pool.Exec(context.Background(), query, token.ID, token.Account, token.Token, token.Description, token.Created, token.Family, token.Consumed,
+		token.CreatedIP, token.UserAgent, token.Device, token.LastUsed, token.LastUsedIP, token.FamilyCreated)
 	return err
 }
 
-// Delete deletes a specific refresh token from a specific account out of the database
-func (service *refreshTokenService) Delete(account, id snowflake.ID) error {
-	query := "DELETE FROM refresh_tokens WHERE id = $1 AND account = $2"
+// errAlreadyConsumed is used to roll back a rotation if the refresh token to consume has already been consumed before
+var errAlreadyConsumed = errors.New("refresh token has already been consumed")
 
-	_, err := service.pool.Exec(context.Background(), query, id, account)
-	return err
+// Rotate atomically marks a specific refresh token as consumed and creates the refresh token replacing it
+// It reports false and creates nothing if the refresh token does not exist or has already been consumed before
+func (service *refreshTokenService) Rotate(consumed snowflake.ID, at int64, rotated *shared.RefreshToken) (bool, error) {
+	err := service.pool.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		tag, err := tx.Exec(context.Background(), "UPDATE refresh_tokens SET consumed = $2 WHERE id = $1 AND consumed IS NULL", consumed, at)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errAlreadyConsumed
+		}
+
+		query := `
+			INSERT INTO refresh_tokens (id, account, token, description, created, family, consumed, created_ip, user_agent, device, last_used, last_used_ip, family_created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		`
+		_, err = tx.Exec(context.Background(), query, rotated.ID, rotated.Account, rotated.Token, rotated.Description, rotated.Created, rotated.Family, rotated.Consumed,
+			rotated.CreatedIP, rotated.UserAgent, rotated.Device, rotated.LastUsed, rotated.LastUsedIP, rotated.FamilyCreated)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errAlreadyConsumed) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteFamily deletes all refresh tokens of a specific family out of the database
+func (service *refreshTokenService) DeleteFamily(family snowflake.ID) (int64, error) {
+	query := "DELETE FROM refresh_tokens WHERE family = $1"
+
+	tag, err := service.pool.Exec(context.Background(), query, family)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
 }
 
 // DeleteAll deletes all refresh tokens from a specific account out of the database
@@ -100,9 +160,9 @@ func (service *refreshTokenService) DeleteAll(account snowflake.ID) error {
 	return err
 }
 
-// DeleteExpired deletes all expired refresh tokens
+// DeleteExpired deletes all refresh tokens of families which have exceeded their lifetime
 func (service *refreshTokenService) DeleteExpired(valid time.Duration) (int64, error) {
-	query := "DELETE FROM refresh_tokens WHERE created < $1"
+	query := "DELETE FROM refresh_tokens WHERE family_created < $1"
 
 	tag, err := service.pool.Exec(context.Background(), query, time.Now().Add(-valid).Unix())
 	if err != nil {
@@ -114,7 +174,8 @@ func (service *refreshTokenService) DeleteExpired(valid time.Duration) (int64, e
 func rowToRefreshToken(row pgx.Row) (*shared.RefreshToken, error) {
 	refreshToken := new(shared.RefreshToken)
 
-	if err := row.Scan(&refreshToken.ID, &refreshToken.Account, &refreshToken.Token, &refreshToken.Description, &refreshToken.Created); err != nil {
+	if err := row.Scan(&refreshToken.ID, &refreshToken.Account, &refreshToken.Token, &refreshToken.Description, &refreshToken.Created, &refreshToken.Family, &refreshToken.Consumed,
+		&refreshToken.CreatedIP, &refreshToken.UserAgent, &refreshToken.Device, &refreshToken.LastUsed, &refreshToken.LastUsedIP, &refreshToken.FamilyCreated); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// twoFactorService represents the postgres two-factor authentication service implementation
+type twoFactorService struct {
+	pool *pgxpool.Pool
+}
+
+// TwoFactor retrieves the two-factor authentication configuration of a specific account out of the database
+func (service *twoFactorService) TwoFactor(account snowflake.ID) (*shared.TwoFactor, error) {
+	query := "SELECT * FROM two_factor WHERE account = $1"
+
+	twoFactor, err := rowToTwoFactor(service.pool.QueryRow(context.Background(), query, account))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return twoFactor, nil
+}
+
+// CreateOrReplace creates or replaces a two-factor authentication configuration inside the database
+func (service *twoFactorService) CreateOrReplace(twoFactor *shared.TwoFactor) error {
+	query := `
+		INSERT INTO two_factor (account, secret, confirmed, last_step, created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account) DO UPDATE
+			SET secret = excluded.secret,
+				confirmed = excluded.confirmed,
+				last_step = excluded.last_step,
+				created = excluded.created
+	`
+
+	_, err := service.pool.Exec(context.Background(), query, twoFactor.Account, twoFactor.Secret, twoFactor.Confirmed, twoFactor.LastStep, twoFactor.Created)
+	return err
+}
+
+// UseStep atomically marks the given TOTP time step of a specific account as used
+// It reports false if a code of the same or a later time step has already been used, which prevents replaying codes
+func (service *twoFactorService) UseStep(account snowflake.ID, step int64) (bool, error) {
+	query := "UPDATE two_factor SET last_step = $2 WHERE account = $1 AND last_step < $2"
+
+	tag, err := service.pool.Exec(context.Background(), query, account, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecoveryCodes retrieves all unused recovery codes of a specific account out of the database
+func (service *twoFactorService) RecoveryCodes(account snowflake.ID) ([]*shared.RecoveryCode, error) {
+	query := "SELECT * FROM recovery_codes WHERE account = $1 ORDER BY created"
+
+	rows, err := service.pool.Query(context.Background(), query, account)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []*shared.RecoveryCode{}, nil
+		}
+		return nil, err
+	}
+
+	codes := []*shared.RecoveryCode{}
+	for rows.Next() {
+		code, err := rowToRecoveryCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// ReplaceRecoveryCodes atomically replaces all recovery codes of a specific account inside the database
+func (service *twoFactorService) ReplaceRecoveryCodes(account snowflake.ID, codes []*shared.RecoveryCode) error {
+	return service.pool.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), "DELETE FROM recovery_codes WHERE account = $1", account); err != nil {
+			return err
+		}
+
+		query := "INSERT INTO recovery_codes (id, account, code, created) VALUES ($1, $2, $3, $4)"
+		for _, code := range codes {
+			if _, err := tx.Exec(context.Background(), query, code.ID, code.Account, code.Code, code.Created); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode atomically deletes a specific recovery code out of the database
+// It reports false if the recovery code has already been used before
+func (service *twoFactorService) UseRecoveryCode(id snowflake.ID) (bool, error) {
+	query := "DELETE FROM recovery_codes WHERE id = $1"
+
+	tag, err := service.pool.Exec(context.Background(), query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Delete deletes the two-factor authentication configuration and all recovery codes of a specific account out of the database
+func (service *twoFactorService) Delete(account snowflake.ID) error {
+	return service.pool.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), "DELETE FROM recovery_codes WHERE account = $1", account); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), "DELETE FROM two_factor WHERE account = $1", account)
+		return err
+	})
+}
+
+func rowToTwoFactor(row pgx.Row) (*shared.TwoFactor, error) {
+	twoFactor := new(shared.TwoFactor)
+
+	if err := row.Scan(&twoFactor.Account, &twoFactor.Secret, &twoFactor.Confirmed, &twoFactor.LastStep, &twoFactor.Created); err != nil {
+		return nil, err
+	}
+
+	return twoFactor, nil
+}
+
+func rowToRecoveryCode(row pgx.Row) (*shared.RecoveryCode, error) {
+	code := new(shared.RecoveryCode)
+
+	if err := row.Scan(&code.ID, &code.Account, &code.Code, &code.Created); err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/static"
+)
+
+// deadLetterService represents the Redis dead letter service implementation
+// The IDs of all dead letters are kept in a sorted set ordered by their creation time while the dead letters
+// themselves are stored as JSON inside a hash
+type deadLetterService struct {
+	rdb *goredis.Client
+}
+
+// Count counts the total amount of dead letters stored inside Redis
+func (service *deadLetterService) Count() (int, error) {
+	count, err := service.rdb.ZCard(context.Background(), static.DeadLettersRedisKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// DeadLetters retrieves the desired amount of dead letters out of Redis
+func (service *deadLetterService) DeadLetters(skip, limit int) ([]*shared.DeadLetter, error) {
+	if limit <= 0 {
+		return []*shared.DeadLetter{}, nil
+	}
+
+	ids, err := service.rdb.ZRange(context.Background(), static.DeadLettersRedisKey, int64(skip), int64(skip+limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*shared.DeadLetter{}, nil
+	}
+
+	values, err := service.rdb.HMGet(context.Background(), static.DeadLettersRedisKey+"_data", ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]*shared.DeadLetter, 0, len(values))
+	for _, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		deadLetter := new(shared.DeadLetter)
+		if err := json.Unmarshal([]byte(raw), deadLetter); err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+// DeadLetter retrieves a specific dead letter out of Redis
+func (service *deadLetterService) DeadLetter(id snowflake.ID) (*shared.DeadLetter, error) {
+	raw, err := service.rdb.HGet(context.Background(), static.DeadLettersRedisKey+"_data", id.String()).Result()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	deadLetter := new(shared.DeadLetter)
+	if err := json.Unmarshal([]byte(raw), deadLetter); err != nil {
+		return nil, err
+	}
+
+	return deadLetter, nil
+}
+
+// CreateOrReplace creates or replaces a dead letter inside Redis
+func (service *deadLetterService) CreateOrReplace(deadLetter *shared.DeadLetter) error {
+	raw, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	_, err = service.rdb.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.HSet(context.Background(), static.DeadLettersRedisKey+"_data", deadLetter.ID.String(), raw)
+		pipe.ZAdd(context.Background(), static.DeadLettersRedisKey, &goredis.Z{
+			Score:  float64(deadLetter.Created),
+			Member: deadLetter.ID.String(),
+		})
+		return nil
+	})
+	return err
+}
+
+// Delete deletes a specific dead letter out of Redis
+func (service *deadLetterService) Delete(id snowflake.ID) error {
+	_, err := service.rdb.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.HDel(context.Background(), static.DeadLettersRedisKey+"_data", id.String())
+		pipe.ZRem(context.Background(), static.DeadLettersRedisKey, id.String())
+		return nil
+	})
+	return err
+}
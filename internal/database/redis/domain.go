@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"context"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/static"
+)
+
+// domainCache represents the Redis domain cache implementation
+// The enabled domain names are kept in a set which is read by the SMTP edge
+type domainCache struct {
+	rdb *goredis.Client
+}
+
+// Domains retrieves all domain names stored inside Redis
+func (cache *domainCache) Domains() ([]string, error) {
+	return cache.rdb.SMembers(context.Background(), static.DomainsRedisKey).Result()
+}
+
+// Replace atomically replaces all domain names stored inside Redis
+func (cache *domainCache) Replace(domains []string) error {
+	_, err := cache.rdb.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.Del(context.Background(), static.DomainsRedisKey)
+		if len(domains) > 0 {
+			members := make([]interface{}, 0, len(domains))
+			for _, domain := range domains {
+				members = append(members, domain)
+			}
+			pipe.SAdd(context.Background(), static.DomainsRedisKey, members...)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,23 @@
+package redis
+
+import goredis "github.com/go-redis/redis/v8"
+
+// redisDriver represents the Redis database driver
+// It is used for data which has to survive an outage of the postgres database or is read by other poopmail services
+type redisDriver struct {
+	DeadLetters *deadLetterService
+	Domains     *domainCache
+	Revocations *revocationService
+	Challenges  *twoFactorChallengeService
+}
+
+// NewDriver creates a new Redis database driver using the given client
+// The token hashing key is used to hash the secrets stored inside Redis
+func NewDriver(rdb *goredis.Client, tokenHashingKey []byte) *redisDriver {
+	return &redisDriver{
+		DeadLetters: &deadLetterService{rdb: rdb},
+		Domains:     &domainCache{rdb: rdb},
+		Revocations: &revocationService{rdb: rdb},
+		Challenges:  &twoFactorChallengeService{rdb: rdb, hashingKey: tokenHashingKey},
+	}
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/static"
+)
+
+// revocationService represents the Redis revocation service implementation
+// Revoked access token IDs and sessions are stored as plain keys, accounts store the time before which all access
+// tokens have been revoked; every key expires on its own once the access tokens it affects have expired
+type revocationService struct {
+	rdb *goredis.Client
+}
+
+// RevokeToken revokes the access token with the given ID
+func (service *revocationService) RevokeToken(id string, ttl time.Duration) error {
+	return service.rdb.Set(context.Background(), static.RevocationsRedisKey+"_token:"+id, 1, ttl).Err()
+}
+
+// RevokeSession revokes all access tokens issued for the given session
+func (service *revocationService) RevokeSession(session snowflake.ID, ttl time.Duration) error {
+	return service.rdb.Set(context.Background(), static.RevocationsRedisKey+"_session:"+session.String(), 1, ttl).Err()
+}
+
+// RevokeAccount revokes all access tokens of the given account issued until the given unix timestamp in milliseconds
+func (service *revocationService) RevokeAccount(account snowflake.ID, before int64, ttl time.Duration) error {
+	return service.rdb.Set(context.Background(), static.RevocationsRedisKey+"_account:"+account.String(), before, ttl).Err()
+}
+
+// Revoked checks whether an access token with the given ID, session, account and issue time in unix milliseconds has been revoked
+func (service *revocationService) Revoked(id string, session, account snowflake.ID, issued int64) (bool, error) {
+	values, err := service.rdb.MGet(context.Background(),
+		static.RevocationsRedisKey+"_token:"+id,
+		static.RevocationsRedisKey+"_session:"+session.String(),
+		static.RevocationsRedisKey+"_account:"+account.String(),
+	).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if values[0] != nil || (session != 0 && values[1] != nil) {
+		return true, nil
+	}
+	if raw, ok := values[2].(string); ok {
+		before, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issued <= before, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/hashing"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/poopmail/canalization/internal/static"
+)
+
+// failChallengeScript increments the failed attempts of a challenge only if it has not expired yet
+// Incrementing the field of an expired challenge would otherwise recreate it without an expiry
+var failChallengeScript = goredis.NewScript(`
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return -1
+	end
+	return redis.call("HINCRBY", KEYS[1], "attempts", 1)
+`)
+
+// recordAccountFailureScript increments the failed attempts of an account and starts the window they are counted in
+// with the first failure
+var recordAccountFailureScript = goredis.NewScript(`
+	local failures = redis.call("INCR", KEYS[1])
+	if failures == 1 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	end
+	return failures
+`)
+
+// twoFactorChallengeService represents the Redis two-factor challenge service implementation
+// Every challenge is stored as a hash under its keyed hash so that the plain challenges never reach Redis
+type twoFactorChallengeService struct {
+	rdb        *goredis.Client
+	hashingKey []byte
+}
+
+// Create creates a new challenge for the given account which expires after the given duration
+func (service *twoFactorChallengeService) Create(challenge string, account snowflake.ID, ttl time.Duration) error {
+	key := service.challengeKey(challenge)
+	_, err := service.rdb.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.HSet(context.Background(), key, "account", account.String(), "attempts", 0)
+		pipe.Expire(context.Background(), key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Challenge retrieves a specific challenge out of Redis
+func (service *twoFactorChallengeService) Challenge(challenge string) (*shared.TwoFactorChallenge, error) {
+	values, err := service.rdb.HGetAll(context.Background(), service.challengeKey(challenge)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	account, err := snowflake.ParseString(values["account"])
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := strconv.Atoi(values["attempts"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &shared.TwoFactorChallenge{
+		Account:  account,
+		Attempts: attempts,
+	}, nil
+}
+
+// Fail records a failed attempt to solve a specific challenge and returns the total amount of failed attempts
+// -1 is returned if the challenge does not exist (anymore)
+func (service *twoFactorChallengeService) Fail(challenge string) (int, error) {
+	attempts, err := failChallengeScript.Run(context.Background(), service.rdb, []string{service.challengeKey(challenge)}).Int()
+	return attempts, err
+}
+
+// Delete deletes a specific challenge out of Redis
+func (service *twoFactorChallengeService) Delete(challenge string) error {
+	return service.rdb.Del(context.Background(), service.challengeKey(challenge)).Err()
+}
+
+// AccountFailures retrieves the amount of failed second factor attempts of a specific account inside the current window
+func (service *twoFactorChallengeService) AccountFailures(account snowflake.ID) (int, error) {
+	failures, err := service.rdb.Get(context.Background(), accountFailuresKey(account)).Int()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return failures, nil
+}
+
+// RecordAccountFailure records a failed second factor attempt of a specific account and returns the amount of failed
+// attempts inside the current window
+func (service *twoFactorChallengeService) RecordAccountFailure(account snowflake.ID, window time.Duration) (int, error) {
+	return recordAccountFailureScript.Run(context.Background(), service.rdb, []string{accountFailuresKey(account)}, window.Milliseconds()).Int()
+}
+
+// ResetAccountFailures resets the failed second factor attempts of a specific account
+func (service *twoFactorChallengeService) ResetAccountFailures(account snowflake.ID) error {
+	return service.rdb.Del(context.Background(), accountFailuresKey(account)).Err()
+}
+
+func accountFailuresKey(account snowflake.ID) string {
+	return static.TwoFactorChallengesRedisKey + "_failures:" + account.String()
+}
+
+func (service *twoFactorChallengeService) challengeKey(challenge string) string {
+	return static.TwoFactorChallengesRedisKey + ":" + hashing.HashToken(service.hashingKey, challenge)
+}
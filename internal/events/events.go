@@ -0,0 +1,176 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/shared"
+	"github.com/sirupsen/logrus"
+)
+
+// Type represents the type of a mailbox event
+type Type string
+
+const (
+	TypeMessageCreated = Type("message.created")
+	TypeMessageDeleted = Type("message.deleted")
+
+	// TypeResync tells a subscriber which did not keep up that it missed events and has to refetch the mailbox
+	// It is the last event of the subscription, which gets closed right after
+	TypeResync = Type("resync")
+)
+
+// subscriberBufferSize represents the amount of events buffered for a single subscriber
+// Subscribers which do not keep up receive a resync event in the last slot and get unsubscribed
+const subscriberBufferSize = 16
+
+// Event represents an event which occurred inside a mailbox
+type Event struct {
+	Type    Type            `json:"type"`
+	Mailbox string          `json:"mailbox"`
+	Message *shared.Message `json:"message"`
+	Created int64           `json:"created"`
+}
+
+// NewMessageEvent creates a new event of the given type concerning the given message
+func NewMessageEvent(typ Type, message *shared.Message) *Event {
+	return &Event{
+		Type:    typ,
+		Mailbox: strings.ToLower(message.Mailbox),
+		Message: message,
+		Created: time.Now().Unix(),
+	}
+}
+
+// Broker fans out mailbox events to local subscribers
+// Events are published through a Redis channel so that the subscribers of all running instances receive them
+type Broker struct {
+	rdb     *redis.Client
+	channel string
+
+	mu          sync.RWMutex
+	closed      bool
+	subscribers map[string]map[chan *Event]struct{}
+}
+
+// NewBroker creates a new event broker using the given Redis client and channel
+func NewBroker(rdb *redis.Client, channel string) *Broker {
+	return &Broker{
+		rdb:         rdb,
+		channel:     channel,
+		subscribers: make(map[string]map[chan *Event]struct{}),
+	}
+}
+
+// Publish publishes the given event to all instances
+func (broker *Broker) Publish(event *Event) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return broker.rdb.Publish(context.Background(), broker.channel, encoded).Err()
+}
+
+// Subscribe subscribes to all events of the given mailbox
+// The returned channel gets closed once the returned unsubscribe function is called, the subscriber falls behind or the broker shuts down
+func (broker *Broker) Subscribe(mailbox string) (<-chan *Event, func()) {
+	mailbox = strings.ToLower(mailbox)
+	channel := make(chan *Event, subscriberBufferSize)
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.closed {
+		close(channel)
+		return channel, func() {}
+	}
+
+	if broker.subscribers[mailbox] == nil {
+		broker.subscribers[mailbox] = make(map[chan *Event]struct{})
+	}
+	broker.subscribers[mailbox][channel] = struct{}{}
+
+	var once sync.Once
+	return channel, func() {
+		once.Do(func() {
+			broker.mu.Lock()
+			defer broker.mu.Unlock()
+
+			if _, ok := broker.subscribers[mailbox][channel]; !ok {
+				return
+			}
+			delete(broker.subscribers[mailbox], channel)
+			if len(broker.subscribers[mailbox]) == 0 {
+				delete(broker.subscribers, mailbox)
+			}
+			close(channel)
+		})
+	}
+}
+
+// Run represents the task which receives published events and dispatches them to the local subscribers
+// All subscriptions get closed once the given context is done
+func (broker *Broker) Run(ctx context.Context) {
+	logrus.Info("Starting the event broker task")
+	pubSub := broker.rdb.Subscribe(ctx, broker.channel)
+	defer pubSub.Close()
+	channel := pubSub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Shutting down the event broker task")
+			broker.close()
+			return
+		case msg := <-channel:
+			event := new(Event)
+			if err := json.Unmarshal([]byte(msg.Payload), event); err != nil {
+				logrus.WithError(err).Error("error while unmarshalling event")
+				continue
+			}
+			broker.dispatch(event)
+		}
+	}
+}
+
+func (broker *Broker) dispatch(event *Event) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	// Channels are only ever sent to while holding the lock, so checking their length first never blocks
+	for channel := range broker.subscribers[event.Mailbox] {
+		if len(channel) < cap(channel)-1 {
+			channel <- event
+			continue
+		}
+
+		logrus.WithField("mailbox", event.Mailbox).Debug("unsubscribing slow subscriber")
+		channel <- &Event{
+			Type:    TypeResync,
+			Mailbox: event.Mailbox,
+			Created: time.Now().Unix(),
+		}
+		delete(broker.subscribers[event.Mailbox], channel)
+		close(channel)
+	}
+	if len(broker.subscribers[event.Mailbox]) == 0 {
+		delete(broker.subscribers, event.Mailbox)
+	}
+}
+
+func (broker *Broker) close() {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	broker.closed = true
+	for mailbox, channels := range broker.subscribers {
+		for channel := range channels {
+			close(channel)
+		}
+		delete(broker.subscribers, mailbox)
+	}
+}
@@ -0,0 +1,20 @@
+package hashing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken hashes the given randomly generated token using a hash keyed with the given key
+// Unlike passwords, random tokens carry enough entropy to not need a slow hash function, which keeps their verification cheap
+func HashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckToken checks the given token by comparing its hash using the given key to the given hash in constant time
+func CheckToken(key []byte, token, hash string) bool {
+	return hmac.Equal([]byte(HashToken(key, token)), []byte(hash))
+}
@@ -0,0 +1,35 @@
+package hashing
+
+import "testing"
+
+func TestHashToken(t *testing.T) {
+	// HMAC-SHA256 test vector taken from https://en.wikipedia.org/wiki/HMAC#Examples
+	hash := HashToken([]byte("key"), "The quick brown fox jumps over the lazy dog")
+	if expected := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; hash != expected {
+		t.Errorf("expected hash %s, got %s", expected, hash)
+	}
+}
+
+func TestHashTokenDependsOnKey(t *testing.T) {
+	if HashToken([]byte("first"), "token") == HashToken([]byte("second"), "token") {
+		t.Error("expected hashes using different keys to differ")
+	}
+	if HashToken(nil, "token") == HashToken([]byte("key"), "token") {
+		t.Error("expected unkeyed and keyed hashes to differ")
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	key := []byte("key")
+	hash := HashToken(key, "token")
+
+	if !CheckToken(key, "token", hash) {
+		t.Error("expected the correct token to be accepted")
+	}
+	if CheckToken(key, "other", hash) {
+		t.Error("expected a wrong token to be rejected")
+	}
+	if CheckToken([]byte("other"), "token", hash) {
+		t.Error("expected a token hashed using another key to be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+package mails
+
+import (
+	"bytes"
+	"mime"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/poopmail/canalization/internal/shared"
+)
+
+// structuredHeaders holds the canonical keys of all headers which are represented by a dedicated field
+var structuredHeaders = map[string]bool{
+	"From":        true,
+	"To":          true,
+	"Cc":          true,
+	"Reply-To":    true,
+	"Message-Id":  true,
+	"In-Reply-To": true,
+	"Date":        true,
+	"Subject":     true,
+}
+
+var wordDecoder = new(mime.WordDecoder)
+
+// collectHeaders returns the headers of the given mail, either the ones sent by the SMTP service or the ones parsed from the raw message
+func collectHeaders(mail *mail) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader, len(mail.Headers))
+	for key, values := range mail.Headers {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	if len(header) > 0 || len(mail.Raw) == 0 {
+		return header
+	}
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(mail.Raw))
+	if err != nil {
+		return header
+	}
+	return textproto.MIMEHeader(msg.Header)
+}
+
+// buildHeaders builds the structured headers of the given mail
+// The envelope sender and recipients are used if the corresponding headers are missing
+func buildHeaders(mail *mail, header textproto.MIMEHeader) *shared.MessageHeaders {
+	headers := &shared.MessageHeaders{
+		MessageID: strings.Trim(strings.TrimSpace(header.Get("Message-Id")), "<>"),
+		InReplyTo: strings.Trim(strings.TrimSpace(header.Get("In-Reply-To")), "<>"),
+		Other:     make(map[string][]string),
+	}
+
+	// Parse the sender
+	if from := parseAddresses(header.Get("From")); len(from) > 0 {
+		headers.From = from[0]
+	} else if mail.From != "" {
+		if from := parseAddresses(mail.From); len(from) > 0 {
+			headers.From = from[0]
+		}
+	}
+
+	// Parse the recipients
+	headers.To = parseAddresses(strings.Join(header.Values("To"), ", "))
+	if len(headers.To) == 0 {
+		for _, to := range mail.To {
+			headers.To = append(headers.To, &shared.MessageAddress{Address: strings.ToLower(to)})
+		}
+	}
+	headers.Cc = parseAddresses(strings.Join(header.Values("Cc"), ", "))
+	headers.ReplyTo = parseAddresses(strings.Join(header.Values("Reply-To"), ", "))
+
+	// Parse the date the sender claims to have sent the mail at
+	if date, err := netmail.ParseDate(header.Get("Date")); err == nil {
+		headers.Date = date.Unix()
+	}
+
+	// Keep all remaining headers as they are
+	for key, values := range header {
+		if structuredHeaders[key] {
+			continue
+		}
+		decoded := make([]string, 0, len(values))
+		for _, value := range values {
+			decoded = append(decoded, decodeHeader(value))
+		}
+		headers.Other[key] = decoded
+	}
+
+	return headers
+}
+
+// parseAddresses parses the given address list
+// Addresses which cannot be parsed are kept as they are
+func parseAddresses(value string) []*shared.MessageAddress {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	parsed, err := (&netmail.AddressParser{WordDecoder: wordDecoder}).ParseList(value)
+	if err != nil {
+		return []*shared.MessageAddress{{Address: strings.TrimSpace(value)}}
+	}
+
+	addresses := make([]*shared.MessageAddress, 0, len(parsed))
+	for _, address := range parsed {
+		addresses = append(addresses, &shared.MessageAddress{
+			Name:    address.Name,
+			Address: strings.ToLower(address.Address),
+		})
+	}
+	return addresses
+}
+
+// decodeHeader decodes all RFC 2047 encoded words inside the given header value
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -4,19 +4,25 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/poopmail/canalization/internal/events"
 	"github.com/poopmail/canalization/internal/id"
 	"github.com/poopmail/canalization/internal/shared"
 	"github.com/sirupsen/logrus"
 )
 
 type mail struct {
-	From    string   `json:"from"`
-	To      []string `json:"to"`
-	Subject string   `json:"subject"`
-	Content content  `json:"content"`
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Subject     string              `json:"subject"`
+	Content     content             `json:"content"`
+	Attachments []*attachment       `json:"attachments"`
+	Raw         []byte              `json:"raw"`
+	Headers     map[string][]string `json:"headers"`
 }
 
 type content struct {
@@ -24,62 +30,316 @@ type content struct {
 	HTML  string `json:"html"`
 }
 
-// Receiver represents the task which receives and processes incoming mails
-func Receiver(ctx context.Context, pubSub *redis.PubSub, mailboxes shared.MailboxService, messages shared.MessageService) {
+type attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	ContentID   string `json:"content_id"`
+	Data        []byte `json:"data"`
+}
+
+// Stage represents the processing stage of an incoming mail
+type Stage string
+
+const (
+	StageDecode    = Stage("decode")
+	StageUnmarshal = Stage("unmarshal")
+	StageLookup    = Stage("lookup")
+	StagePersist   = Stage("persist")
+	StageUnknown   = Stage("unknown")
+)
+
+// ProcessingError represents an error which occurred while processing an incoming mail
+type ProcessingError struct {
+	Stage Stage
+	Err   error
+}
+
+// Error returns the string representation of the processing error
+func (err *ProcessingError) Error() string {
+	return "error while processing incoming mail (" + string(err.Stage) + "): " + err.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (err *ProcessingError) Unwrap() error {
+	return err.Err
+}
+
+// Processor represents the component which writes incoming mails into their corresponding mailboxes
+type Processor struct {
+	Mailboxes   shared.MailboxService
+	Messages    shared.MessageService
+	Attachments shared.AttachmentService
+	DeadLetters shared.DeadLetterService
+	Events      *events.Broker
+
+	// SubaddressDelimiters holds the characters separating the local part of an address from its tag
+	// Mails for 'alice+shop@domain' land in 'alice@domain' if '+' is one of them; no sub-addressing is done if it is empty
+	SubaddressDelimiters string
+}
+
+// Receive processes the given mail payload and moves it into the dead letter queue if it can never be processed
+// An error is returned if processing failed for a potentially transient reason, so the caller may retry it later,
+// or if the payload could not be moved into the dead letter queue
+func (processor *Processor) Receive(payload, delivery string) error {
+	err := processor.Process(payload, delivery)
+	if err == nil {
+		return nil
+	}
+	if !IsPermanent(err) {
+		return err
+	}
+	return processor.bury(payload, delivery, err)
+}
+
+// bury moves the given mail payload which failed to be processed with the given error into the dead letter queue
+func (processor *Processor) bury(payload, delivery string, err error) error {
+	now := time.Now().Unix()
+	deadLetter := &shared.DeadLetter{
+		ID:          id.Generate(),
+		Payload:     payload,
+		Delivery:    delivery,
+		Attempts:    1,
+		LastAttempt: now,
+		Created:     now,
+	}
+	deadLetter.Stage, deadLetter.Error = describeError(err)
+	if dlErr := processor.DeadLetters.CreateOrReplace(deadLetter); dlErr != nil {
+		logrus.WithError(dlErr).WithField("processing_error", err.Error()).Error("could not store dead letter")
+		return dlErr
+	}
+
+	logrus.WithError(err).WithField("dead_letter", deadLetter.ID).Warn("moved incoming mail into the dead letter queue")
+	return nil
+}
+
+// Retry processes the payload of the given dead letter again and updates it if processing fails again
+// The dead letter is deleted if the payload was processed successfully
+func (processor *Processor) Retry(deadLetter *shared.DeadLetter) error {
+	// Dead letters created before deliveries were tracked are identified by themselves
+	delivery := deadLetter.Delivery
+	if delivery == "" {
+		delivery = deadLetter.ID.String()
+	}
+
+	err := processor.Process(deadLetter.Payload, delivery)
+	if err == nil {
+		return processor.DeadLetters.Delete(deadLetter.ID)
+	}
+
+	deadLetter.Stage, deadLetter.Error = describeError(err)
+	deadLetter.Attempts++
+	deadLetter.LastAttempt = time.Now().Unix()
+	if dlErr := processor.DeadLetters.CreateOrReplace(deadLetter); dlErr != nil {
+		return dlErr
+	}
+	return err
+}
+
+// IsPermanent checks whether the given processing error will occur again no matter how often the mail is processed
+// This is the case if the payload itself is malformed
+func IsPermanent(err error) bool {
+	var processingErr *ProcessingError
+	if !errors.As(err, &processingErr) {
+		return false
+	}
+	return processingErr.Stage == StageDecode || processingErr.Stage == StageUnmarshal
+}
+
+func describeError(err error) (string, string) {
+	var processingErr *ProcessingError
+	if errors.As(err, &processingErr) {
+		return string(processingErr.Stage), processingErr.Err.Error()
+	}
+	return string(StageUnknown), err.Error()
+}
+
+// Process decodes the given Base64 encoded mail payload and writes it into all mailboxes it is addressed to
+// The delivery uniquely identifies the incoming mail; mailboxes which already received it are skipped when it gets
+// processed again after a partial failure
+func (processor *Processor) Process(payload, delivery string) error {
+	// Decode the incoming mail
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return &ProcessingError{Stage: StageDecode, Err: err}
+	}
+
+	// Unmarshal the incoming mail
+	mail := new(mail)
+	if err := json.Unmarshal(decoded, mail); err != nil {
+		return &ProcessingError{Stage: StageUnmarshal, Err: err}
+	}
+
+	// Fill in the content using the raw message if the SMTP service did not parse it
+	if mail.Content.Plain == "" && mail.Content.HTML == "" && len(mail.Raw) > 0 {
+		plain, html, err := parseContent(mail.Raw)
+		if err != nil {
+			logrus.WithError(err).Warn("error while parsing raw incoming mail")
+		}
+		mail.Content.Plain = plain
+		mail.Content.HTML = html
+	}
+
+	// Build the structured headers of the mail
+	header := collectHeaders(mail)
+	headers := buildHeaders(mail, header)
+	if mail.Subject == "" {
+		mail.Subject = decodeHeader(header.Get("Subject"))
+	}
+
+	// Retrieve the corresponding mailboxes together with the recipients and tags the mail was sent to
+	var addresses []string
+	recipients := make(map[string]string, len(mail.To))
+	tags := make(map[string]string, len(mail.To))
+	for _, to := range mail.To {
+		mailbox, tag, err := processor.lookupMailbox(to)
+		if err != nil {
+			return &ProcessingError{Stage: StageLookup, Err: err}
+		}
+		if mailbox == nil {
+			continue
+		}
+		if _, ok := recipients[mailbox.Address]; !ok {
+			addresses = append(addresses, mailbox.Address)
+			recipients[mailbox.Address] = to
+			tags[mailbox.Address] = tag
+		}
+	}
+
+	// Write the mail to the database
+	now := time.Now().Unix()
+	for _, address := range addresses {
+		message := &shared.Message{
+			ID:        id.Generate(),
+			Mailbox:   address,
+			From:      mail.From,
+			Subject:   mail.Subject,
+			Tag:       tags[address],
+			Recipient: recipients[address],
+			Headers:   headers,
+			Content: &shared.MessageContent{
+				Plain: mail.Content.Plain,
+				HTML:  mail.Content.HTML,
+			},
+			Created:  now,
+			Delivery: delivery,
+		}
+		attachments := buildAttachments(message, mail.Attachments)
+
+		created, err := processor.persist(message, attachments, mail.Raw)
+		if err != nil {
+			return &ProcessingError{Stage: StagePersist, Err: err}
+		}
+		if !created {
+			continue
+		}
+
+		// Notify all subscribers of the mailbox about the new message
+		message.Attachments = attachments
+		if err := processor.Events.Publish(events.NewMessageEvent(events.TypeMessageCreated, message)); err != nil {
+			logrus.WithError(err).Error("error while publishing message event")
+		}
+	}
+
+	return nil
+}
+
+// lookupMailbox retrieves the mailbox the given recipient address belongs to
+// If no mailbox with the exact address exists, the sub-address tag is stripped off and the parent mailbox is looked up.
+// The most specific matching pattern mailbox is used as the last resort.
+func (processor *Processor) lookupMailbox(address string) (*shared.Mailbox, string, error) {
+	mailbox, err := processor.Mailboxes.Mailbox(address)
+	if err != nil || mailbox != nil {
+		return mailbox, "", err
+	}
+
+	if at := strings.LastIndex(address, "@"); at >= 0 && processor.SubaddressDelimiters != "" {
+		local, domain := address[:at], address[at:]
+		if delimiter := strings.IndexAny(local, processor.SubaddressDelimiters); delimiter > 0 {
+			mailbox, err = processor.Mailboxes.Mailbox(local[:delimiter] + domain)
+			if err != nil || mailbox != nil {
+				return mailbox, strings.ToLower(local[delimiter+1:]), err
+			}
+		}
+	}
+
+	mailbox, err = processor.Mailboxes.MatchPattern(address)
+	return mailbox, "", err
+}
+
+// persist writes a message together with its attachments and raw source into the database
+// The message is removed again if any of its parts could not be written; the returned boolean reports false if
+// the mailbox of the message already received the same delivery before
+func (processor *Processor) persist(message *shared.Message, attachments []*shared.Attachment, raw []byte) (bool, error) {
+	created, err := processor.Messages.Create(message)
+	if err != nil || !created {
+		return false, err
+	}
+
+	err = func() error {
+		if len(raw) > 0 {
+			if err := processor.Messages.CreateOrReplaceRaw(message.ID, raw); err != nil {
+				return err
+			}
+		}
+		for _, attachment := range attachments {
+			if err := processor.Attachments.CreateOrReplace(attachment); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		if err := processor.Messages.Delete(message.ID); err != nil {
+			logrus.WithError(err).Error("error while deleting incomplete message")
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// buildAttachments creates the attachments of the given message
+// Inline 'cid:' references inside its HTML content are kept and replaced by signed URLs whenever the message is served
+func buildAttachments(message *shared.Message, incoming []*attachment) []*shared.Attachment {
+	attachments := make([]*shared.Attachment, 0, len(incoming))
+	for _, raw := range incoming {
+		attachment := &shared.Attachment{
+			ID:          id.Generate(),
+			Message:     message.ID,
+			Filename:    raw.Filename,
+			ContentType: raw.ContentType,
+			Size:        raw.Size,
+			ContentID:   strings.Trim(raw.ContentID, "<>"),
+			Data:        raw.Data,
+			Created:     message.Created,
+		}
+		if attachment.ContentType == "" {
+			attachment.ContentType = "application/octet-stream"
+		}
+		if attachment.Size <= 0 {
+			attachment.Size = int64(len(attachment.Data))
+		}
+
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// Receiver represents the task which receives and processes incoming mails published via Redis Pub/Sub
+func Receiver(ctx context.Context, pubSub *redis.PubSub, processor *Processor) {
 	logrus.Info("Starting the mail receiving task")
 	channel := pubSub.Channel()
 
-loop:
 	for {
 		select {
 		case <-ctx.Done():
 			logrus.Info("Shutting down the mail receiving task")
 			return
 		case msg := <-channel:
-			// Decode the incoming mail
-			decoded, err := base64.StdEncoding.DecodeString(msg.Payload)
-			if err != nil {
-				logrus.WithError(err).Error("error while decoding incoming mail")
-				continue loop
-			}
-
-			// Unmarshal the incoming mail
-			mail := new(mail)
-			if err := json.Unmarshal(decoded, mail); err != nil {
-				logrus.WithError(err).Error("error while unmarshalling incoming mail")
-				continue loop
-			}
-
-			// Retrieve the corresponding mailboxes
-			addresses := make([]string, 0, len(mail.To))
-			for _, to := range mail.To {
-				mailbox, err := mailboxes.Mailbox(to)
-				if err != nil {
-					logrus.WithError(err).Error()
-					continue loop
-				}
-				if mailbox != nil {
-					addresses = append(addresses, mailbox.Address)
-				}
-			}
-
-			// Write the mail to the database
-			now := time.Now().Unix()
-			for _, address := range addresses {
-				message := &shared.Message{
-					ID:      id.Generate(),
-					Mailbox: address,
-					From:    mail.From,
-					Subject: mail.Subject,
-					Content: &shared.MessageContent{
-						Plain: mail.Content.Plain,
-						HTML:  mail.Content.HTML,
-					},
-					Created: now,
-				}
-				if err := messages.CreateOrReplace(message); err != nil {
-					logrus.WithError(err).Error("error while creating message from incoming mail")
-				}
+			// Mails published via Pub/Sub are never redelivered, so every one of them is a delivery of its own
+			if err := processor.Receive(msg.Payload, id.Generate().String()); err != nil {
+				logrus.WithError(err).Error("error while processing incoming mail")
 			}
 		}
 	}
@@ -0,0 +1,101 @@
+package mails
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// parseContent parses the given raw RFC 5322 message and extracts its plain text and HTML content
+func parseContent(raw []byte) (string, string, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", "", err
+	}
+
+	var plain, html string
+	err = walkPart(textproto.MIMEHeader(msg.Header), msg.Body, func(mediaType string, body string) {
+		switch {
+		case mediaType == "text/plain" && plain == "":
+			plain = body
+		case mediaType == "text/html" && html == "":
+			html = body
+		}
+	})
+	return plain, html, err
+}
+
+// walkPart walks through the given MIME part recursively and calls the given function for every inline text part
+func walkPart(header textproto.MIMEHeader, body io.Reader, fn func(mediaType, body string)) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	// Walk through all sub parts of multipart parts
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := walkPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Skip every non-text part and every part which is explicitly marked as an attachment
+	if !strings.HasPrefix(mediaType, "text/") {
+		return nil
+	}
+	if disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); disposition == "attachment" {
+		return nil
+	}
+
+	decoded, err := ioutil.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	fn(mediaType, decodeCharset(params["charset"], decoded))
+	return nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeCharset converts the given bytes into a string
+// Only UTF-8 compatible and Latin-1 charsets are converted, all others are interpreted as UTF-8
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		return string(data)
+	}
+}
@@ -0,0 +1,142 @@
+package mails
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// StreamPayloadField represents the field of a stream entry which holds the Base64 encoded mail
+const StreamPayloadField = "payload"
+
+const (
+	streamReadCount   = 16
+	streamReadBlock   = 5 * time.Second
+	streamErrorDelay  = 5 * time.Second
+	streamClaimPeriod = 30 * time.Second
+)
+
+// StreamOptions holds the options used by the stream based mail receiving task
+type StreamOptions struct {
+	Stream    string
+	Group     string
+	Consumer  string
+	ClaimIdle time.Duration
+}
+
+// StreamReceiver represents the task which receives and processes incoming mails using a Redis Streams consumer group.
+// Entries are only acknowledged once they were processed successfully or moved into the dead letter queue because they
+// are malformed, so mails stay pending across restarts and transient failures and get reclaimed from idle consumers
+// after the configured idle time.
+func StreamReceiver(ctx context.Context, rdb *redis.Client, options StreamOptions, processor *Processor) {
+	logrus.WithFields(logrus.Fields{
+		"stream":   options.Stream,
+		"group":    options.Group,
+		"consumer": options.Consumer,
+	}).Info("Starting the stream mail receiving task")
+
+	// Create the consumer group if it does not exist yet
+	for {
+		err := rdb.XGroupCreateMkStream(ctx, options.Stream, options.Group, "0").Err()
+		if err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			break
+		}
+		logrus.WithError(err).Error("error while creating the mails consumer group")
+		select {
+		case <-ctx.Done():
+			logrus.Info("Shutting down the stream mail receiving task")
+			return
+		case <-time.After(streamErrorDelay):
+		}
+	}
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Shutting down the stream mail receiving task")
+			return
+		default:
+		}
+
+		// Reclaim entries other consumers did not acknowledge in time
+		if time.Since(lastClaim) >= streamClaimPeriod {
+			if err := claimPendingMails(ctx, rdb, options, processor); err != nil && !errors.Is(err, context.Canceled) {
+				logrus.WithError(err).Error("error while reclaiming pending mails")
+			}
+			lastClaim = time.Now()
+		}
+
+		// Read new entries from the stream
+		streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    options.Group,
+			Consumer: options.Consumer,
+			Streams:  []string{options.Stream, ">"},
+			Count:    streamReadCount,
+			Block:    streamReadBlock,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+				continue
+			}
+			logrus.WithError(err).Error("error while reading incoming mails")
+			select {
+			case <-ctx.Done():
+			case <-time.After(streamErrorDelay):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			processStreamMails(ctx, rdb, options, processor, stream.Messages)
+		}
+	}
+}
+
+func claimPendingMails(ctx context.Context, rdb *redis.Client, options StreamOptions, processor *Processor) error {
+	start := "0-0"
+	for {
+		messages, next, err := rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   options.Stream,
+			Group:    options.Group,
+			Consumer: options.Consumer,
+			MinIdle:  options.ClaimIdle,
+			Start:    start,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(messages) > 0 {
+			logrus.WithField("amount", len(messages)).Info("Reclaimed pending mails")
+			processStreamMails(ctx, rdb, options, processor, messages)
+		}
+
+		if next == "0-0" || next == "" {
+			return nil
+		}
+		start = next
+	}
+}
+
+func processStreamMails(ctx context.Context, rdb *redis.Client, options StreamOptions, processor *Processor, messages []redis.XMessage) {
+	for _, message := range messages {
+		payload, _ := message.Values[StreamPayloadField].(string)
+
+		// Leave the entry pending so that it gets retried if it failed for a transient reason or could not be moved into
+		// the dead letter queue
+		if err := processor.Receive(payload, options.Stream+":"+message.ID); err != nil {
+			logrus.WithError(err).WithField("entry", message.ID).Error("error while processing incoming mail; leaving it pending")
+			continue
+		}
+
+		if err := rdb.XAck(ctx, options.Stream, options.Group, message.ID).Err(); err != nil {
+			logrus.WithError(err).WithField("entry", message.ID).Error("error while acknowledging incoming mail")
+		}
+	}
+}
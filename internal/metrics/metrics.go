@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"expvar"
+	"time"
+)
+
+// Names of the exported counters
+const (
+	RetentionSweeps            = "retention_sweeps"
+	RetentionSweepFailures     = "retention_sweep_failures"
+	RetentionDeletedMessages   = "retention_deleted_messages"
+	RetentionAffectedMailboxes = "retention_affected_mailboxes"
+	RetentionLastSweep         = "retention_last_sweep"
+)
+
+// registry holds all counters of the service; it is published via expvar as well
+var registry = expvar.NewMap("canalization")
+
+// Add adds the given delta to the counter with the given name
+func Add(name string, delta int64) {
+	registry.Add(name, delta)
+}
+
+// SetTime sets the gauge with the given name to the given time as a unix timestamp
+func SetTime(name string, t time.Time) {
+	value := new(expvar.Int)
+	value.Set(t.Unix())
+	registry.Set(name, value)
+}
+
+// JSON encodes all counters into a JSON object
+func JSON() string {
+	return registry.String()
+}
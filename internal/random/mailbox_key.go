@@ -0,0 +1,84 @@
+package random
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// KeyStrategy represents a strategy used to generate random mailbox keys
+type KeyStrategy string
+
+const (
+	KeyStrategyWords         = KeyStrategy("words")
+	KeyStrategyHex           = KeyStrategy("hex")
+	KeyStrategyAdjectiveNoun = KeyStrategy("adjective_noun")
+)
+
+const (
+	hexKeyLength       = 12
+	wordsKeySyllables  = 4
+	keySuffixMaxNumber = 1000
+)
+
+var (
+	syllableConsonants = []string{"b", "d", "f", "g", "k", "l", "m", "n", "p", "r", "s", "t", "v", "z"}
+	syllableVowels     = []string{"a", "e", "i", "o", "u"}
+
+	adjectives = []string{
+		"able", "agile", "amber", "ancient", "bold", "brave", "breezy", "bright", "calm", "clever",
+		"cosmic", "crimson", "curious", "dapper", "eager", "early", "electric", "fancy", "fearless", "fluffy",
+		"frosty", "fuzzy", "gentle", "giant", "golden", "happy", "hidden", "humble", "icy", "jolly",
+		"keen", "lively", "lucky", "mellow", "mighty", "misty", "nimble", "noble", "odd", "plucky",
+		"polite", "proud", "quick", "quiet", "rapid", "rusty", "shiny", "silent", "silly", "sleepy",
+		"snowy", "sparkly", "speedy", "spicy", "steady", "sunny", "swift", "tidy", "tiny", "vivid",
+		"wandering", "witty", "young", "zesty",
+	}
+	nouns = []string{
+		"badger", "beacon", "beetle", "bison", "breeze", "canyon", "cactus", "comet", "cookie", "coral",
+		"crane", "dolphin", "dragon", "falcon", "ferret", "forest", "fox", "galaxy", "gecko", "glacier",
+		"harbor", "hedgehog", "heron", "island", "jaguar", "koala", "lagoon", "lantern", "lemur", "lobster",
+		"meadow", "meteor", "moose", "narwhal", "nebula", "otter", "owl", "panda", "parrot", "pebble",
+		"penguin", "pigeon", "planet", "puffin", "quokka", "raccoon", "river", "rocket", "salmon", "squirrel",
+		"sparrow", "summit", "tiger", "toucan", "tulip", "turtle", "valley", "volcano", "walrus", "willow",
+		"wombat", "yak", "zebra",
+	}
+)
+
+// IsValidKeyStrategy checks whether the given key strategy is known
+func IsValidKeyStrategy(strategy KeyStrategy) bool {
+	switch strategy {
+	case KeyStrategyWords, KeyStrategyHex, KeyStrategyAdjectiveNoun:
+		return true
+	default:
+		return false
+	}
+}
+
+// MailboxKey generates a random mailbox key using the given strategy
+// If suffixed is true a random number gets appended to reduce the chance of collisions
+func MailboxKey(strategy KeyStrategy, suffixed bool) string {
+	var key string
+	switch strategy {
+	case KeyStrategyWords:
+		key = pronounceableWord(wordsKeySyllables)
+	case KeyStrategyAdjectiveNoun:
+		key = adjectives[rand.Intn(len(adjectives))] + "_" + nouns[rand.Intn(len(nouns))]
+	default:
+		key = Hex(hexKeyLength)
+	}
+
+	if suffixed {
+		key += strconv.Itoa(rand.Intn(keySuffixMaxNumber))
+	}
+	return key
+}
+
+func pronounceableWord(syllables int) string {
+	var builder strings.Builder
+	for i := 0; i < syllables; i++ {
+		builder.WriteString(syllableConsonants[rand.Intn(len(syllableConsonants))])
+		builder.WriteString(syllableVowels[rand.Intn(len(syllableVowels))])
+	}
+	return builder.String()
+}
@@ -1,6 +1,8 @@
 package random
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -12,6 +14,7 @@ func init() {
 var (
 	allowedCharacters      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+*#-_.,"
 	allowedCharactersRunes = []rune(allowedCharacters)
+	hexCharacters          = "0123456789abcdef"
 )
 
 // RandomString generates a random string with a specified length
@@ -22,3 +25,26 @@ func RandomString(length int) string {
 	}
 	return string(runes)
 }
+
+// Hex generates a random lowercase hexadecimal string with a specified length
+func Hex(length int) string {
+	bytes := make([]byte, length)
+	for i := range bytes {
+		bytes[i] = hexCharacters[rand.Intn(len(hexCharacters))]
+	}
+	return string(bytes)
+}
+
+// SecureBytes generates the given amount of cryptographically secure random bytes
+func SecureBytes(amount int) []byte {
+	buffer := make([]byte, amount)
+	if _, err := cryptorand.Read(buffer); err != nil {
+		panic(err)
+	}
+	return buffer
+}
+
+// SecureHex generates a cryptographically secure random lowercase hexadecimal string out of the given amount of bytes
+func SecureHex(bytes int) string {
+	return hex.EncodeToString(SecureBytes(bytes))
+}
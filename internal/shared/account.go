@@ -4,11 +4,12 @@ import "github.com/bwmarrin/snowflake"
 
 // Account represents an user account
 type Account struct {
-	ID       snowflake.ID `json:"id"`
-	Username string       `json:"username"`
-	Password string       `json:"password,omitempty"`
-	Admin    bool         `json:"admin"`
-	Created  int64        `json:"created"`
+	ID        snowflake.ID `json:"id"`
+	Username  string       `json:"username"`
+	Password  string       `json:"password,omitempty"`
+	Admin     bool         `json:"admin"`
+	Created   int64        `json:"created"`
+	Retention *int64       `json:"retention"`
 }
 
 // AccountService represents a service which keeps track of user accounts
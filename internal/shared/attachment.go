@@ -0,0 +1,22 @@
+package shared
+
+import "github.com/bwmarrin/snowflake"
+
+// Attachment represents a file attached to an email message
+type Attachment struct {
+	ID          snowflake.ID `json:"id"`
+	Message     snowflake.ID `json:"message"`
+	Filename    string       `json:"filename"`
+	ContentType string       `json:"content_type"`
+	Size        int64        `json:"size"`
+	ContentID   string       `json:"content_id"`
+	Data        []byte       `json:"-"`
+	Created     int64        `json:"created"`
+}
+
+// AttachmentService represents a service which keeps track of message attachments
+type AttachmentService interface {
+	AttachmentsOfMessages(messages []snowflake.ID) (map[snowflake.ID][]*Attachment, error)
+	Attachment(message, id snowflake.ID) (*Attachment, error)
+	CreateOrReplace(attachment *Attachment) error
+}
@@ -0,0 +1,24 @@
+package shared
+
+import "github.com/bwmarrin/snowflake"
+
+// DeadLetter represents an incoming mail payload which could not be processed
+type DeadLetter struct {
+	ID          snowflake.ID `json:"id"`
+	Payload     string       `json:"payload"`
+	Delivery    string       `json:"delivery"`
+	Stage       string       `json:"stage"`
+	Error       string       `json:"error"`
+	Attempts    int          `json:"attempts"`
+	LastAttempt int64        `json:"last_attempt"`
+	Created     int64        `json:"created"`
+}
+
+// DeadLetterService represents a service which keeps track of dead letters
+type DeadLetterService interface {
+	Count() (int, error)
+	DeadLetters(skip, limit int) ([]*DeadLetter, error)
+	DeadLetter(id snowflake.ID) (*DeadLetter, error)
+	CreateOrReplace(deadLetter *DeadLetter) error
+	Delete(id snowflake.ID) error
+}
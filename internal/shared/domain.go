@@ -0,0 +1,41 @@
+package shared
+
+import "github.com/bwmarrin/snowflake"
+
+// Domain represents a domain mails can be received on
+type Domain struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+
+	// Public reports whether every account may create mailboxes on the domain
+	// Restricted domains are reserved for their owner and admins
+	Public  bool  `json:"public"`
+	Created int64 `json:"created"`
+
+	// Owner holds the account which registered the domain; domains added by admins have no owner
+	Owner *snowflake.ID `json:"owner"`
+
+	// Verified reports whether the owner proved the ownership of the domain using a DNS TXT record holding the verification token
+	Verified          bool   `json:"verified"`
+	VerificationToken string `json:"verification_token,omitempty"`
+
+	// Mailboxes holds the amount of mailboxes on the domain and is ignored when a domain is written
+	Mailboxes int `json:"mailboxes"`
+}
+
+// DomainService represents a service which keeps track of domains
+type DomainService interface {
+	Domains() ([]*Domain, error)
+	Enabled() ([]*Domain, error)
+	CountOwnedBy(owner snowflake.ID) (int, error)
+	OwnedBy(owner snowflake.ID) ([]*Domain, error)
+	Domain(name string) (*Domain, error)
+	CreateOrReplace(domain *Domain) error
+	Delete(name string) error
+}
+
+// DomainCache represents the set of enabled domain names the SMTP edge uses to decide which mails to accept
+type DomainCache interface {
+	Domains() ([]string, error)
+	Replace(domains []string) error
+}
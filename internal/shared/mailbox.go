@@ -4,9 +4,14 @@ import "github.com/bwmarrin/snowflake"
 
 // Mailbox represents a simple mailbox mapped to an user account
 type Mailbox struct {
-	Address string       `json:"address"`
-	Account snowflake.ID `json:"account"`
-	Created int64        `json:"created"`
+	Address   string       `json:"address"`
+	Account   snowflake.ID `json:"account"`
+	Created   int64        `json:"created"`
+	Expires   *int64       `json:"expires"`
+	Retention *int64       `json:"retention"`
+
+	// Pattern reports whether the local part of the address is a glob pattern ('*' and '?') matching several addresses
+	Pattern bool `json:"pattern"`
 }
 
 // MailboxService represents a service which keeps track of mailboxes
@@ -15,8 +20,14 @@ type MailboxService interface {
 	Mailboxes(skip, limit int) ([]*Mailbox, error)
 	CountInAccount(account snowflake.ID) (int, error)
 	MailboxesInAccount(account snowflake.ID, skip, limit int) ([]*Mailbox, error)
+	CountAccessible(account snowflake.ID) (int, error)
+	MailboxesAccessible(account snowflake.ID, skip, limit int) ([]*Mailbox, map[string]MailboxRole, error)
 	Mailbox(address string) (*Mailbox, error)
-	CreateOrReplace(mailbox *Mailbox) error
+	MatchPattern(address string) (*Mailbox, error)
+	Expired() ([]*Mailbox, error)
+	Create(mailbox *Mailbox) (bool, error)
+	UpdateSettings(mailbox *Mailbox) error
 	Delete(address string) error
 	DeleteInAccount(account snowflake.ID) error
+	DeleteInDomain(domain string) error
 }
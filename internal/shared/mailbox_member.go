@@ -0,0 +1,31 @@
+package shared
+
+import "github.com/bwmarrin/snowflake"
+
+// MailboxRole represents the role an account a mailbox got shared with has
+type MailboxRole string
+
+const (
+	// MailboxRoleViewer allows to read the messages of a mailbox
+	MailboxRoleViewer = MailboxRole("viewer")
+
+	// MailboxRoleManager additionally allows to modify and delete messages and to manage the members of a mailbox
+	MailboxRoleManager = MailboxRole("manager")
+)
+
+// MailboxMember represents an account a mailbox got shared with
+type MailboxMember struct {
+	Mailbox string       `json:"mailbox"`
+	Account snowflake.ID `json:"account"`
+	Role    MailboxRole  `json:"role"`
+	Created int64        `json:"created"`
+}
+
+// MailboxMemberService represents a service which keeps track of mailbox members
+type MailboxMemberService interface {
+	Members(mailbox string) ([]*MailboxMember, error)
+	Member(mailbox string, account snowflake.ID) (*MailboxMember, error)
+	CreateOrReplace(member *MailboxMember) error
+	Delete(mailbox string, account snowflake.ID) error
+	DeleteInAccount(account snowflake.ID) error
+}
@@ -0,0 +1,22 @@
+package shared
+
+import "github.com/bwmarrin/snowflake"
+
+// MailboxTransfer represents a pending transfer of a mailbox to another account
+// The receiving account has to accept the transfer before the ownership of the mailbox changes
+type MailboxTransfer struct {
+	Mailbox string       `json:"mailbox"`
+	From    snowflake.ID `json:"from"`
+	To      snowflake.ID `json:"to"`
+	Created int64        `json:"created"`
+}
+
+// MailboxTransferService represents a service which keeps track of pending mailbox transfers
+type MailboxTransferService interface {
+	Transfer(mailbox string) (*MailboxTransfer, error)
+	TransfersTo(account snowflake.ID) ([]*MailboxTransfer, error)
+	CreateOrReplace(transfer *MailboxTransfer) error
+	Execute(mailbox *Mailbox) error
+	Delete(mailbox string) error
+	DeleteInAccount(account snowflake.ID) error
+}
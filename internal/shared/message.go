@@ -1,15 +1,56 @@
 package shared
 
-import "github.com/bwmarrin/snowflake"
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
 
 // Message represents an incoming email message
 type Message struct {
-	ID      snowflake.ID    `json:"id"`
-	Mailbox string          `json:"mailbox"`
-	From    string          `json:"from"`
-	Subject string          `json:"subject"`
-	Content *MessageContent `json:"content"`
-	Created int64           `json:"created"`
+	ID          snowflake.ID    `json:"id"`
+	Mailbox     string          `json:"mailbox"`
+	From        string          `json:"from"`
+	Subject     string          `json:"subject"`
+	Tag         string          `json:"tag"`
+	Recipient   string          `json:"recipient"`
+	Headers     *MessageHeaders `json:"headers"`
+	Content     *MessageContent `json:"content"`
+	Attachments []*Attachment   `json:"attachments"`
+	Seen        bool            `json:"seen"`
+	Flagged     bool            `json:"flagged"`
+	Archived    bool            `json:"archived"`
+	Created     int64           `json:"created"`
+
+	// Delivery identifies the delivery of the incoming mail the message was created from
+	// Every mailbox receives at most one message per delivery, so redelivered mails are not written twice
+	Delivery string `json:"-"`
+}
+
+// MessageFlags represents an update of the flags of one or more messages
+// Flags which are nil are left untouched
+type MessageFlags struct {
+	Seen     *bool `json:"seen"`
+	Flagged  *bool `json:"flagged"`
+	Archived *bool `json:"archived"`
+}
+
+// MessageHeaders represents the structured headers of an incoming email message
+type MessageHeaders struct {
+	From      *MessageAddress     `json:"from,omitempty"`
+	To        []*MessageAddress   `json:"to,omitempty"`
+	Cc        []*MessageAddress   `json:"cc,omitempty"`
+	ReplyTo   []*MessageAddress   `json:"reply_to,omitempty"`
+	MessageID string              `json:"message_id,omitempty"`
+	InReplyTo string              `json:"in_reply_to,omitempty"`
+	Date      int64               `json:"date,omitempty"`
+	Other     map[string][]string `json:"other,omitempty"`
+}
+
+// MessageAddress represents a single address mentioned in the headers of an email message
+type MessageAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
 }
 
 // MessageContent represents the content of an incoming email message
@@ -18,12 +59,62 @@ type MessageContent struct {
 	HTML  string `json:"html"`
 }
 
+// MessageFilter represents a set of conditions messages have to match
+// Empty fields are ignored
+type MessageFilter struct {
+	Mailbox   string
+	After     snowflake.ID
+	Subject   string
+	From      string
+	To        string
+	MessageID string
+	InReplyTo string
+	Tag       string
+	Seen      *bool
+	Flagged   *bool
+	Archived  *bool
+}
+
+// MessageSearch represents a full-text search across messages
+type MessageSearch struct {
+	Query string
+
+	// Mailbox restricts the search to a single mailbox if it is not empty
+	Mailbox string
+
+	// Account restricts the search to the mailboxes a specific account owns or is a member of if it is not nil
+	Account *snowflake.ID
+}
+
+// MessageSearchResult represents a single message matching a full-text search
+type MessageSearchResult struct {
+	Message    *Message           `json:"message"`
+	Rank       float32            `json:"rank"`
+	Highlights *MessageHighlights `json:"highlights"`
+}
+
+// MessageHighlights holds the parts of a message matching a full-text search with the matches wrapped in <mark> tags
+// The remaining text is HTML-escaped
+type MessageHighlights struct {
+	Subject string `json:"subject"`
+	Content string `json:"content"`
+}
+
 // MessageService represents a service which keeps track of messages
 type MessageService interface {
-	Count(mailbox string) (int, error)
-	Messages(mailbox string, skip, limit int) ([]*Message, error)
+	Count(filter *MessageFilter) (int, error)
+	Messages(filter *MessageFilter, skip, limit int) ([]*Message, error)
+	CountSearch(search *MessageSearch) (int, error)
+	Search(search *MessageSearch, skip, limit int) ([]*MessageSearchResult, error)
 	Message(id snowflake.ID) (*Message, error)
-	CreateOrReplace(message *Message) error
+	MessageMailboxes(ids []snowflake.ID) (map[snowflake.ID]string, error)
+	Create(message *Message) (bool, error)
+	UpdateFlags(ids []snowflake.ID, flags *MessageFlags) error
+	CountUnseen(mailboxes []string) (map[string]int, error)
+	Raw(id snowflake.ID) ([]byte, error)
+	CreateOrReplaceRaw(id snowflake.ID, raw []byte) error
 	Delete(id snowflake.ID) error
 	DeleteInMailbox(mailbox string) error
+	DeleteInDomain(domain string) error
+	DeleteRetained(fallback time.Duration, batchSize int) (map[string]int64, error)
 }
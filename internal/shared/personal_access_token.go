@@ -0,0 +1,28 @@
+package shared
+
+import (
+	"github.com/bwmarrin/snowflake"
+)
+
+// PersonalAccessToken represents a long-lived access token of an account which is restricted to a set of scopes
+type PersonalAccessToken struct {
+	ID       snowflake.ID `json:"id"`
+	Account  snowflake.ID `json:"account"`
+	Name     string       `json:"name"`
+	Token    string       `json:"-"`
+	Scopes   []string     `json:"scopes"`
+	Expires  *int64       `json:"expires"`
+	LastUsed *int64       `json:"last_used"`
+	Created  int64        `json:"created"`
+}
+
+// PersonalAccessTokenService represents a service which keeps track of personal access tokens
+type PersonalAccessTokenService interface {
+	Count(account snowflake.ID) (int, error)
+	PersonalAccessTokens(account snowflake.ID, skip, limit int) ([]*PersonalAccessToken, error)
+	PersonalAccessToken(id snowflake.ID) (*PersonalAccessToken, error)
+	CreateOrReplace(token *PersonalAccessToken) error
+	UpdateLastUsed(id snowflake.ID, lastUsed int64) error
+	Delete(id snowflake.ID) error
+	DeleteAll(account snowflake.ID) error
+}
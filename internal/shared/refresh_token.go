@@ -7,12 +7,22 @@ import (
 )
 
 // RefreshToken represents an accounts refresh token
+// Every use of a refresh token consumes it and issues a new one inside the same family
+// The lifetime of every refresh token is enforced from the creation time of its family, so rotation does not extend it
 type RefreshToken struct {
-	ID          snowflake.ID `json:"id"`
-	Account     snowflake.ID `json:"account"`
-	Token       string       `json:"token,omitempty"`
-	Description string       `json:"description"`
-	Created     int64        `json:"created"`
+	ID            snowflake.ID `json:"id"`
+	Account       snowflake.ID `json:"account"`
+	Token         string       `json:"token,omitempty"`
+	Description   string       `json:"description"`
+	Created       int64        `json:"created"`
+	Family        snowflake.ID `json:"family"`
+	FamilyCreated int64        `json:"family_created"`
+	Consumed      *int64       `json:"-"`
+	CreatedIP     string       `json:"created_ip"`
+	UserAgent     string       `json:"user_agent"`
+	Device        string       `json:"device"`
+	LastUsed      *int64       `json:"last_used"`
+	LastUsedIP    string       `json:"last_used_ip"`
 }
 
 // RefreshTokenService represents a service which keeps track of account refresh tokens
@@ -20,8 +30,10 @@ type RefreshTokenService interface {
 	Count(account snowflake.ID) (int, error)
 	RefreshTokens(account snowflake.ID, skip, limit int) ([]*RefreshToken, error)
 	RefreshToken(account snowflake.ID, id snowflake.ID) (*RefreshToken, error)
+	RefreshTokenByID(id snowflake.ID) (*RefreshToken, error)
 	CreateOrReplace(token *RefreshToken) error
-	Delete(account snowflake.ID, id snowflake.ID) error
+	Rotate(consumed snowflake.ID, at int64, rotated *RefreshToken) (bool, error)
+	DeleteFamily(family snowflake.ID) (int64, error)
 	DeleteAll(account snowflake.ID) error
 	DeleteExpired(valid time.Duration) (int64, error)
 }
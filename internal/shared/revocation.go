@@ -0,0 +1,16 @@
+package shared
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+// RevocationService represents a service which keeps track of access tokens revoked before their expiry
+// Every entry only has to be kept until the access tokens it affects have expired anyway
+type RevocationService interface {
+	RevokeToken(id string, ttl time.Duration) error
+	RevokeSession(session snowflake.ID, ttl time.Duration) error
+	RevokeAccount(account snowflake.ID, before int64, ttl time.Duration) error
+	Revoked(id string, session, account snowflake.ID, issued int64) (bool, error)
+}
@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+// TwoFactor represents the TOTP two-factor authentication configuration of an account
+// It only protects the account once it got confirmed using a valid code
+type TwoFactor struct {
+	Account   snowflake.ID `json:"account"`
+	Secret    string       `json:"-"`
+	Confirmed bool         `json:"confirmed"`
+	LastStep  int64        `json:"-"`
+	Created   int64        `json:"created"`
+}
+
+// RecoveryCode represents a one-time code which may be used instead of a TOTP code
+type RecoveryCode struct {
+	ID      snowflake.ID `json:"id"`
+	Account snowflake.ID `json:"account"`
+	Code    string       `json:"-"`
+	Created int64        `json:"created"`
+}
+
+// TwoFactorService represents a service which keeps track of two-factor authentication configurations and recovery codes
+type TwoFactorService interface {
+	TwoFactor(account snowflake.ID) (*TwoFactor, error)
+	CreateOrReplace(twoFactor *TwoFactor) error
+	UseStep(account snowflake.ID, step int64) (bool, error)
+	RecoveryCodes(account snowflake.ID) ([]*RecoveryCode, error)
+	ReplaceRecoveryCodes(account snowflake.ID, codes []*RecoveryCode) error
+	UseRecoveryCode(id snowflake.ID) (bool, error)
+	Delete(account snowflake.ID) error
+}
+
+// TwoFactorChallenge represents a login which has passed the password check and waits for a second factor
+type TwoFactorChallenge struct {
+	Account  snowflake.ID `json:"account"`
+	Attempts int          `json:"attempts"`
+}
+
+// TwoFactorChallengeService represents a service which keeps track of pending two-factor login challenges and failed
+// second factor attempts of accounts
+type TwoFactorChallengeService interface {
+	Create(challenge string, account snowflake.ID, ttl time.Duration) error
+	Challenge(challenge string) (*TwoFactorChallenge, error)
+	Fail(challenge string) (int, error)
+	Delete(challenge string) error
+	AccountFailures(account snowflake.ID) (int, error)
+	RecordAccountFailure(account snowflake.ID, window time.Duration) (int, error)
+	ResetAccountFailures(account snowflake.ID) error
+}
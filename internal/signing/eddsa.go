@@ -0,0 +1,50 @@
+package signing
+
+import (
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA represents the EdDSA signing method using Ed25519 keys
+// The JWT library in use does not ship an implementation of it
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+// Alg returns the JWT algorithm name of the signing method
+func (method *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign signs the given signing string using the given Ed25519 private key
+func (method *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+// Verify verifies the given signature of the given signing string using the given Ed25519 public key
+func (method *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	decoded, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), decoded) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
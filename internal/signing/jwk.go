@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+// JWK represents a public JSON Web Key as described in RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS represents a JSON Web Key Set as described in RFC 7517
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// buildJWK builds the JSON Web Key of the given public key without its key ID
+func buildJWK(public interface{}) *JWK {
+	switch public := public.(type) {
+	case ed25519.PublicKey:
+		return &JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			Algorithm: SigningMethodEdDSA.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(public),
+		}
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	default:
+		return nil
+	}
+}
+
+// thumbprint calculates the JWK thumbprint of the given JSON Web Key as described in RFC 7638
+// It is used as the key ID so that every replica derives the same ID out of the same key
+func thumbprint(jwk *JWK) string {
+	// The required members have to be ordered lexicographically, which encoding/json does for maps
+	members := map[string]string{"kty": jwk.KeyType}
+	if jwk.KeyType == "OKP" {
+		members["crv"] = jwk.Curve
+		members["x"] = jwk.X
+	} else {
+		members["n"] = jwk.N
+		members["e"] = jwk.E
+	}
+
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,190 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// key represents a single key used to sign or verify access tokens
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+	jwk     *JWK
+}
+
+// KeySet represents the set of keys used to sign and verify access tokens
+// If no asymmetric signing key is configured, access tokens are signed and verified using HS256 and a shared secret
+type KeySet struct {
+	signing *key
+	keys    map[string]*key
+	secret  []byte
+}
+
+// NewHMACKeySet creates a new key set signing and verifying access tokens using HS256 and the given secret
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{secret: secret}
+}
+
+// LoadKeySet loads the PEM encoded private key used to sign access tokens out of the given file together with the
+// keys out of the given verification key files
+// Verification key files may contain public or private keys and are used to keep access tokens signed by previous
+// keys valid while rotating keys.
+func LoadKeySet(signingKeyFile string, verificationKeyFiles []string) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*key)}
+
+	signingKeys, err := loadKeys(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(signingKeys) != 1 || signingKeys[0].private == nil {
+		return nil, fmt.Errorf("signing key file %s has to contain exactly one private key", signingKeyFile)
+	}
+	set.signing = signingKeys[0]
+	set.keys[set.signing.id] = set.signing
+
+	for _, file := range verificationKeyFiles {
+		if file == "" {
+			continue
+		}
+		keys, err := loadKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			set.keys[key.id] = key
+		}
+	}
+
+	return set, nil
+}
+
+// Sign signs the given claims using the signing key of the key set
+func (set *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if set.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(set.secret)
+	}
+
+	token := jwt.NewWithClaims(set.signing.method, claims)
+	token.Header["kid"] = set.signing.id
+	return token.SignedString(set.signing.private)
+}
+
+// Keyfunc looks up the key needed to verify the given token
+// The signing method of the token has to match the one of the key to prevent algorithm confusion.
+func (set *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if set.signing == nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return set.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown key ID")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// JWKS builds the JSON Web Key Set containing the public keys of all keys of the key set
+// It is empty if access tokens are signed using a shared secret.
+func (set *KeySet) JWKS() *JWKS {
+	jwks := &JWKS{Keys: make([]*JWK, 0, len(set.keys))}
+	for _, key := range set.keys {
+		if key != set.signing {
+			jwks.Keys = append(jwks.Keys, key.jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+
+	// The current signing key always comes first
+	if set.signing != nil {
+		jwks.Keys = append([]*JWK{set.signing.jwk}, jwks.Keys...)
+	}
+	return jwks
+}
+
+// loadKeys loads all PEM encoded Ed25519 and RSA keys out of the given file
+func loadKeys(file string) ([]*key, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*key
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+
+		key, err := parseKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no PEM encoded keys found", file)
+	}
+	return keys, nil
+}
+
+// parseKey parses a single PEM block holding either a PKCS #8 or PKCS #1 private key or a PKIX public key
+func parseKey(block *pem.Block) (*key, error) {
+	var private, public interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &key{private: private}
+	switch typed := private.(type) {
+	case ed25519.PrivateKey:
+		public = typed.Public()
+	case *rsa.PrivateKey:
+		public = &typed.PublicKey
+	}
+
+	switch public.(type) {
+	case ed25519.PublicKey:
+		parsed.method = SigningMethodEdDSA
+	case *rsa.PublicKey:
+		parsed.method = jwt.SigningMethodRS256
+	default:
+		return nil, errors.New("unsupported key type; only Ed25519 and RSA keys are supported")
+	}
+
+	parsed.public = public
+	parsed.jwk = buildJWK(public)
+	parsed.id = thumbprint(parsed.jwk)
+	parsed.jwk.KeyID = parsed.id
+	return parsed, nil
+}
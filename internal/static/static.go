@@ -15,4 +15,13 @@ var (
 	// DomainsRedisKey represents the Redis key under which all valid domains are saved
 	// As this key should not change in any time we just force it here
 	DomainsRedisKey = "__domains"
+
+	// DeadLettersRedisKey represents the Redis key prefix under which all incoming mails which could not be processed are saved
+	DeadLettersRedisKey = "__dead_letters"
+
+	// RevocationsRedisKey represents the Redis key prefix under which all revoked access tokens, sessions and accounts are saved
+	RevocationsRedisKey = "__revocations"
+
+	// TwoFactorChallengesRedisKey represents the Redis key prefix under which all pending two-factor login challenges are saved
+	TwoFactorChallengesRedisKey = "__two_factor_challenges"
 )
@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/poopmail/canalization/internal/random"
+)
+
+const (
+	// Period represents the amount of seconds a single code is valid for
+	Period = 30
+
+	// Digits represents the amount of digits a code consists of
+	Digits = 6
+
+	// Skew represents the amount of periods before and after the current one whose codes are accepted as well
+	// This compensates clock drift between the server and the authenticator
+	Skew = 1
+
+	// secretLength represents the amount of random bytes a secret consists of
+	secretLength = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret generates a new random base32 encoded secret
+func GenerateSecret() string {
+	return encoding.EncodeToString(random.SecureBytes(secretLength))
+}
+
+// URI builds the otpauth URI authenticator apps use to enroll the given secret
+func URI(issuer, accountName, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+accountName) + "?" + query.Encode()
+}
+
+// Step calculates the time step the given time lies in
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Validate checks whether the given code is valid for the given secret at the given time
+// The time step the code belongs to is returned so that callers are able to reject replayed codes.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if hmac.Equal([]byte(generate(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Code generates the code of the given secret at the given time
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return generate(key, Step(t)), nil
+}
+
+// generate generates the code of the given key for the given time step as described in RFC 4226 and RFC 6238
+func generate(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo)
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the base32 encoded SHA1 secret '12345678901234567890' used by the test vectors of RFC 6238
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// rfcVectors holds the SHA1 test vectors of RFC 6238, appendix B, cut down to the last six digits
+var rfcVectors = []struct {
+	time int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerate(t *testing.T) {
+	for _, vector := range rfcVectors {
+		if code := generate([]byte("12345678901234567890"), Step(time.Unix(vector.time, 0))); code != vector.code {
+			t.Errorf("time %d: expected code %s, got %s", vector.time, vector.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, vector := range rfcVectors {
+		at := time.Unix(vector.time, 0)
+		step, valid := Validate(rfcSecret, vector.code, at)
+		if !valid {
+			t.Errorf("time %d: expected code %s to be valid", vector.time, vector.code)
+			continue
+		}
+		if step != Step(at) {
+			t.Errorf("time %d: expected step %d, got %d", vector.time, Step(at), step)
+		}
+	}
+}
+
+func TestValidateNormalizesInput(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	if _, valid := Validate(strings.ToLower(rfcSecret), " 050471 ", at); !valid {
+		t.Error("expected a lower case secret and surrounding whitespace to be accepted")
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	for _, code := range []string{"", "05047", "0504710", "abcdef"} {
+		if _, valid := Validate(rfcSecret, code, at); valid {
+			t.Errorf("expected code %q to be rejected", code)
+		}
+	}
+	if _, valid := Validate("not base32!", "050471", at); valid {
+		t.Error("expected an invalid secret to be rejected")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	key, _ := encoding.DecodeString(rfcSecret)
+	at := time.Unix(1234567890, 0)
+	current := Step(at)
+
+	for offset := int64(-Skew - 1); offset <= Skew+1; offset++ {
+		step, valid := Validate(rfcSecret, generate(key, current+offset), at)
+		expected := offset >= -Skew && offset <= Skew
+		if valid != expected {
+			t.Errorf("offset %d: expected validity %t, got %t", offset, expected, valid)
+			continue
+		}
+		if valid && step != current+offset {
+			t.Errorf("offset %d: expected step %d, got %d", offset, current+offset, step)
+		}
+	}
+}
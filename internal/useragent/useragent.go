@@ -0,0 +1,79 @@
+package useragent
+
+import "strings"
+
+// MaxLength represents the maximum length of user agents worth storing
+const MaxLength = 512
+
+// token represents a user agent substring identifying a specific browser or operating system
+type token struct {
+	needle string
+	name   string
+}
+
+// browsers is ordered so that more specific browsers are checked before the engines they are based on
+var browsers = []token{
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"vivaldi", "Vivaldi"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chromium", "Chromium"},
+	{"chrome", "Chrome"},
+	{"safari", "Safari"},
+	{"curl", "curl"},
+	{"wget", "Wget"},
+	{"python-requests", "Python Requests"},
+	{"go-http-client", "Go HTTP client"},
+	{"postmanruntime", "Postman"},
+	{"insomnia", "Insomnia"},
+}
+
+// systems is ordered so that more specific operating systems are checked before the ones they are based on
+var systems = []token{
+	{"android", "Android"},
+	{"iphone", "iOS"},
+	{"ipad", "iPadOS"},
+	{"; cros", "ChromeOS"},
+	{"windows", "Windows"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// Describe builds a short human-readable description like 'Firefox on Linux' out of the given user agent
+// An empty string is returned if neither the browser nor the operating system could be detected
+func Describe(userAgent string) string {
+	lower := strings.ToLower(userAgent)
+	browser := find(browsers, lower)
+	system := find(systems, lower)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	default:
+		return system
+	}
+}
+
+// Truncate cuts the given user agent off after MaxLength bytes without leaving invalid UTF-8 behind
+func Truncate(userAgent string) string {
+	if len(userAgent) <= MaxLength {
+		return userAgent
+	}
+	return strings.ToValidUTF8(userAgent[:MaxLength], "")
+}
+
+func find(tokens []token, userAgent string) string {
+	for _, token := range tokens {
+		if strings.Contains(userAgent, token.needle) {
+			return token.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package validation
+
+import (
+	"strings"
+)
+
+var (
+	maxDomainNameLength         = 253
+	maxDomainLabelLength        = 63
+	allowedDomainNameCharacters = "abcdefghijklmnopqrstuvwxyz0123456789-"
+)
+
+// ValidateDomainName validates a lowercase domain name
+func ValidateDomainName(name string) bool {
+	if len(name) > maxDomainNameLength {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > maxDomainLabelLength || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, char := range label {
+			if !strings.ContainsRune(allowedDomainNameCharacters, char) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -16,3 +16,33 @@ func ValidateMailboxKey(key string) bool {
 
 	return true
 }
+
+var allowedMailboxPatternCharacters = allowedMailboxKeyCharacters + "*?-."
+
+// ValidateMailboxPattern validates a mailbox key pattern
+// Patterns may additionally contain the '*' and '?' wildcards as well as '-' and '.'
+func ValidateMailboxPattern(pattern string) bool {
+	for _, char := range pattern {
+		if !strings.ContainsRune(allowedMailboxPatternCharacters, char) {
+			return false
+		}
+	}
+
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// minUnprivilegedPatternPrefix represents the minimum amount of literal characters a mailbox key pattern has to start
+// with to be usable by everyone
+const minUnprivilegedPatternPrefix = 3
+
+// IsPrivilegedPattern checks whether the given mailbox key pattern catches too large a part of a domain to be used by
+// everyone
+// Only patterns starting with a fixed literal prefix like 'shop-*' are unprivileged; patterns like '*', '*e*' or
+// 'a*' would catch almost all mails sent to a domain.
+func IsPrivilegedPattern(pattern string) bool {
+	prefix := pattern
+	if index := strings.IndexAny(pattern, "*?"); index >= 0 {
+		prefix = pattern[:index]
+	}
+	return len([]rune(prefix)) < minUnprivilegedPatternPrefix
+}
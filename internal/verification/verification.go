@@ -0,0 +1,70 @@
+package verification
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// RecordPrefix represents the label prepended to a domain to get the name of its verification TXT record
+	RecordPrefix = "_canalization."
+
+	// ValuePrefix represents the prefix of the verification TXT record value which is followed by the verification token
+	ValuePrefix = "canalization-verification="
+)
+
+// dialTimeout represents the time after which connecting to a custom nameserver is aborted
+const dialTimeout = 5 * time.Second
+
+// TXTResolver represents a component which is able to look up the TXT records of a domain
+// *net.Resolver satisfies this interface
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewResolver creates a new TXT resolver which queries the nameserver with the given address
+// The system resolver is used if the address is empty
+func NewResolver(address string) TXTResolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: dialTimeout}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// RecordName returns the name of the TXT record which has to hold the verification token of the given domain
+func RecordName(domain string) string {
+	return RecordPrefix + domain
+}
+
+// RecordValue returns the value the verification TXT record has to hold for the given token
+func RecordValue(token string) string {
+	return ValuePrefix + token
+}
+
+// Verify checks whether the verification TXT record of the given domain holds the given token
+func Verify(ctx context.Context, resolver TXTResolver, domain, token string) (bool, error) {
+	records, err := resolver.LookupTXT(ctx, RecordName(domain))
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	expected := RecordValue(token)
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return true, nil
+		}
+	}
+	return false, nil
+}